@@ -0,0 +1,92 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"io/fs"
+	"syscall"
+
+	"github.com/wtsi-ssg/wrstat/stat"
+)
+
+// atimeCheckMinFiles is the minimum number of regular files we need to have
+// seen before we'll warn that atimes may be unreliable.
+const atimeCheckMinFiles = 10
+
+// atimeChecker records how many regular files have an atime equal to their
+// mtime. If that's all of them, the filesystem is probably mounted noatime, so
+// atimes can't be relied upon to tell if data is cold.
+type atimeChecker struct {
+	files int
+	equal int
+}
+
+// Add is a stat.Operation that counts path if it's a regular file, noting if
+// its atime equals its mtime.
+func (a *atimeChecker) Add(path string, info fs.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	a.files++
+
+	if sys.Atim.Sec == sys.Mtim.Sec {
+		a.equal++
+	}
+
+	return nil
+}
+
+// unreliable returns true if we saw enough regular files, and all of them had
+// an atime equal to their mtime.
+func (a *atimeChecker) unreliable() bool {
+	return a.files >= atimeCheckMinFiles && a.equal == a.files
+}
+
+// addAtimeCheckOperation adds an operation to Paths that checks the atimes of
+// files. It returns a function that you should call after calling p.Scan(),
+// which warns if atimes look unreliable.
+func addAtimeCheckOperation(input string, p *stat.Paths) (func() error, error) {
+	a := &atimeChecker{}
+
+	if err := p.AddOperation("atime", a.Add); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		if a.unreliable() {
+			warn("all %d files in %s have an atime equal to their mtime; atimes may be unreliable "+
+				"(is the filesystem mounted noatime?)", a.files, input)
+		}
+
+		return nil
+	}, nil
+}
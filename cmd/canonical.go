@@ -0,0 +1,224 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rootAlias is a path prefix that should be rewritten to a canonical one.
+type rootAlias struct {
+	alias     string
+	canonical string
+}
+
+// rootAliases is a slice of rootAlias, longest alias first.
+type rootAliases []*rootAlias
+
+// parseCanonicalRoots parses the given alias=canonical strings, as supplied to
+// --canonical_root. Dies if any aren't a pair of absolute paths.
+func parseCanonicalRoots(specs []string) rootAliases {
+	aliases := make(rootAliases, len(specs))
+
+	for i, spec := range specs {
+		parts := strings.Split(spec, "=")
+		if len(parts) != 2 || !filepath.IsAbs(parts[0]) || !filepath.IsAbs(parts[1]) {
+			die("--canonical_root must be of the form /alias/path=/canonical/path, not '%s'", spec)
+		}
+
+		aliases[i] = &rootAlias{alias: filepath.Clean(parts[0]), canonical: filepath.Clean(parts[1])}
+	}
+
+	sort.Slice(aliases, func(i, j int) bool {
+		return len(aliases[i].alias) > len(aliases[j].alias)
+	})
+
+	return aliases
+}
+
+// find returns the rootAlias whose alias is path or a parent of path, or nil
+// if there isn't one.
+func (r rootAliases) find(path string) *rootAlias {
+	return r.findIgnoring(path, nil)
+}
+
+// findIgnoring is like find(), but never returns the given rootAlias, so that
+// for a nested alias you can find the alias it is nested within.
+func (r rootAliases) findIgnoring(path string, ignore *rootAlias) *rootAlias {
+	for _, ra := range r {
+		if ra == ignore {
+			continue
+		}
+
+		if path == ra.alias || strings.HasPrefix(path, ra.alias+"/") {
+			return ra
+		}
+	}
+
+	return nil
+}
+
+// rewrite returns path with any alias prefix replaced with its canonical root.
+func (r rootAliases) rewrite(path string) string {
+	return r.rewriteIgnoring(path, nil)
+}
+
+// rewriteIgnoring is like rewrite(), but ignores the given rootAlias.
+func (r rootAliases) rewriteIgnoring(path string, ignore *rootAlias) string {
+	ra := r.findIgnoring(path, ignore)
+	if ra == nil {
+		return path
+	}
+
+	return ra.canonical + strings.TrimPrefix(path, ra.alias)
+}
+
+// rewriteStatsLine returns the given .stats file line with its base64 encoded
+// path rewritten.
+func (r rootAliases) rewriteStatsLine(line string) string {
+	cols := strings.SplitN(line, "\t", 2) //nolint:gomnd
+
+	path, err := base64.StdEncoding.DecodeString(cols[0])
+	if err != nil {
+		die("bad base64 path in stats line: %s", err)
+	}
+
+	cols[0] = base64.StdEncoding.EncodeToString([]byte(r.rewrite(string(path))))
+
+	return strings.Join(cols, "\t")
+}
+
+// userGroupLines returns the byusergroup lines (split in to columns) that
+// should replace the given one. A line for a directory within an alias is
+// rewritten to be within the canonical root. The line for an alias itself
+// additionally results in lines that remove its counts from the parents of the
+// alias, and add them to the parents of the canonical root.
+//
+// If the alias is nested within another alias, its counts are instead removed
+// from the parents of where the other alias would have rewritten it to, since
+// that's where the line for the other alias moved them.
+func (r rootAliases) userGroupLines(cols []string) [][]string {
+	dir := cols[2]
+
+	ra := r.find(dir)
+	if ra == nil {
+		return [][]string{cols}
+	}
+
+	rewritten := append([]string{}, cols...)
+	rewritten[2] = r.rewrite(dir)
+	lines := [][]string{rewritten}
+
+	if dir != ra.alias {
+		return lines
+	}
+
+	count, size := atoi(cols[3]), atoi(cols[4])
+
+	lines = append(lines, parentUserGroupLines(cols[0], cols[1], r.rewriteIgnoring(dir, ra), -count, -size)...)
+
+	return append(lines, parentUserGroupLines(cols[0], cols[1], ra.canonical, count, size)...)
+}
+
+// parentUserGroupLines returns byusergroup lines for each parent directory of
+// dir, with the given user, group, count and size.
+func parentUserGroupLines(user, group, dir string, count, size int64) [][]string {
+	var lines [][]string
+
+	for dir = filepath.Dir(dir); dir != "/"; dir = filepath.Dir(dir) {
+		lines = append(lines, []string{user, group, dir,
+			strconv.FormatInt(count, intBase), strconv.FormatInt(size, intBase)})
+	}
+
+	return lines
+}
+
+// canonicaliseUserGroupStream rewrites the pre-sorted byusergroup data using
+// userGroupLines(), pipes the results through `sort`, and returns the sorted
+// output, ready for summing with mergeSummaryLines(). Also returns a function
+// you should call after you've finished reading the output to cleanup.
+func (r rootAliases) canonicaliseUserGroupStream(data io.Reader) (io.ReadCloser, func() error, error) {
+	cmd := exec.Command("sort")
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+
+	sortStdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sortOutput, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errw := r.writeUserGroupLines(data, sortStdin)
+
+		if errc := sortStdin.Close(); errw == nil {
+			errw = errc
+		}
+
+		errCh <- errw
+	}()
+
+	return sortOutput, func() error {
+		if errw := <-errCh; errw != nil {
+			return errw
+		}
+
+		return cmd.Wait()
+	}, nil
+}
+
+// writeUserGroupLines writes the userGroupLines() of each line in data to the
+// output.
+func (r rootAliases) writeUserGroupLines(data io.Reader, output io.Writer) error {
+	scanner := bufio.NewScanner(data)
+
+	for scanner.Scan() {
+		for _, cols := range r.userGroupLines(strings.Split(scanner.Text(), "\t")) {
+			if _, err := output.Write([]byte(strings.Join(cols, "\t") + "\n")); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
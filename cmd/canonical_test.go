@@ -0,0 +1,168 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCanonicalRoots(t *testing.T) {
+	Convey("Given some canonical roots", t, func() {
+		aliases := parseCanonicalRoots([]string{"/nfs/x=/mnt/x", "/nfs/x/deep=/mnt/deep"})
+
+		Convey("They are sorted longest alias first", func() {
+			So(len(aliases), ShouldEqual, 2)
+			So(aliases[0].alias, ShouldEqual, "/nfs/x/deep")
+			So(aliases[1].alias, ShouldEqual, "/nfs/x")
+		})
+
+		Convey("Paths within an alias are rewritten, and others are not", func() {
+			So(aliases.rewrite("/nfs/x"), ShouldEqual, "/mnt/x")
+			So(aliases.rewrite("/nfs/x/a/b"), ShouldEqual, "/mnt/x/a/b")
+			So(aliases.rewrite("/nfs/x/deep/c"), ShouldEqual, "/mnt/deep/c")
+			So(aliases.rewrite("/nfs/xy"), ShouldEqual, "/nfs/xy")
+			So(aliases.rewrite("/nfs"), ShouldEqual, "/nfs")
+		})
+
+		Convey("Stats lines have just their path rewritten", func() {
+			encode := base64.StdEncoding.EncodeToString
+			line := encode([]byte("/nfs/x/a.file")) + "\t10\t1\t2"
+
+			So(aliases.rewriteStatsLine(line), ShouldEqual, encode([]byte("/mnt/x/a.file"))+"\t10\t1\t2")
+		})
+
+		Convey("Usergroup lines within an alias are rewritten", func() {
+			So(aliases.userGroupLines([]string{"u", "g", "/nfs", "2", "10"}), ShouldResemble,
+				[][]string{{"u", "g", "/nfs", "2", "10"}})
+			So(aliases.userGroupLines([]string{"u", "g", "/nfs/x/a", "2", "10"}), ShouldResemble,
+				[][]string{{"u", "g", "/mnt/x/a", "2", "10"}})
+		})
+
+		Convey("The usergroup line for an alias moves its counts between parents", func() {
+			So(aliases.userGroupLines([]string{"u", "g", "/nfs/x", "2", "10"}), ShouldResemble, [][]string{
+				{"u", "g", "/mnt/x", "2", "10"},
+				{"u", "g", "/nfs", "-2", "-10"},
+				{"u", "g", "/mnt", "2", "10"},
+			})
+
+			So(aliases.userGroupLines([]string{"u", "g", "/nfs/x/deep", "1", "5"}), ShouldResemble, [][]string{
+				{"u", "g", "/mnt/deep", "1", "5"},
+				{"u", "g", "/mnt/x", "-1", "-5"},
+				{"u", "g", "/mnt", "-1", "-5"},
+				{"u", "g", "/mnt", "1", "5"},
+			})
+
+			So(parentUserGroupLines("u", "g", "/a/b/c", 1, 5), ShouldResemble, [][]string{
+				{"u", "g", "/a/b", "1", "5"},
+				{"u", "g", "/a", "1", "5"},
+			})
+		})
+
+		Convey("Usergroup data from 2 aliased inputs merges in to a single tree", func() {
+			input := strings.Join([]string{
+				"u\tg\t/mnt\t1\t5",
+				"u\tg\t/mnt/x\t1\t5",
+				"u\tg\t/mnt/x/b\t1\t5",
+				"u\tg\t/nfs\t3\t15",
+				"u\tg\t/nfs/other\t1\t5",
+				"u\tg\t/nfs/x\t2\t10",
+				"u\tg\t/nfs/x/a\t2\t10",
+			}, "\n") + "\n"
+
+			canonical, cleanup, err := aliases.canonicaliseUserGroupStream(strings.NewReader(input))
+			So(err, ShouldBeNil)
+
+			var output bytes.Buffer
+
+			err = mergeSummaryLinesWith(canonical, userGroupSumCols, &output, writeNonEmptySummaryLine)
+			So(err, ShouldBeNil)
+			So(cleanup(), ShouldBeNil)
+
+			So(output.String(), ShouldEqual, strings.Join([]string{
+				"u\tg\t/mnt\t3\t15",
+				"u\tg\t/mnt/x\t3\t15",
+				"u\tg\t/mnt/x/a\t2\t10",
+				"u\tg\t/mnt/x/b\t1\t5",
+				"u\tg\t/nfs\t1\t5",
+				"u\tg\t/nfs/other\t1\t5",
+			}, "\n")+"\n")
+		})
+
+		Convey("Usergroup data within nested aliases merges in to the right places", func() {
+			input := strings.Join([]string{
+				"u\tg\t/nfs\t3\t15",
+				"u\tg\t/nfs/x\t2\t10",
+				"u\tg\t/nfs/x/a\t1\t5",
+				"u\tg\t/nfs/x/deep\t1\t5",
+				"u\tg\t/nfs/y\t1\t5",
+			}, "\n") + "\n"
+
+			canonical, cleanup, err := aliases.canonicaliseUserGroupStream(strings.NewReader(input))
+			So(err, ShouldBeNil)
+
+			var output bytes.Buffer
+
+			err = mergeSummaryLinesWith(canonical, userGroupSumCols, &output, writeNonEmptySummaryLine)
+			So(err, ShouldBeNil)
+			So(cleanup(), ShouldBeNil)
+
+			So(output.String(), ShouldEqual, strings.Join([]string{
+				"u\tg\t/mnt\t2\t10",
+				"u\tg\t/mnt/deep\t1\t5",
+				"u\tg\t/mnt/x\t1\t5",
+				"u\tg\t/mnt/x/a\t1\t5",
+				"u\tg\t/nfs\t1\t5",
+				"u\tg\t/nfs/y\t1\t5",
+			}, "\n")+"\n")
+		})
+
+		Convey("Parents of an alias left with no files are dropped, but only when canonicalising", func() {
+			input := "u\tg\t/nfs\t2\t10\nu\tg\t/nfs/x\t2\t10\n"
+
+			canonical, cleanup, err := aliases.canonicaliseUserGroupStream(strings.NewReader(input))
+			So(err, ShouldBeNil)
+
+			var output bytes.Buffer
+
+			err = mergeSummaryLinesWith(canonical, userGroupSumCols, &output, writeNonEmptySummaryLine)
+			So(err, ShouldBeNil)
+			So(cleanup(), ShouldBeNil)
+			So(output.String(), ShouldEqual, "u\tg\t/mnt\t2\t10\nu\tg\t/mnt/x\t2\t10\n")
+
+			output.Reset()
+
+			err = mergeSummaryLines(io.NopCloser(strings.NewReader("u\tg\t/a\t0\t0\n")), userGroupSumCols, &output)
+			So(err, ShouldBeNil)
+			So(output.String(), ShouldEqual, "u\tg\t/a\t0\t0\n")
+		})
+	})
+}
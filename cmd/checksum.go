@@ -0,0 +1,161 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+const checksumSuffix = ".checksum"
+const defaultChecksumAlgorithm = "md5"
+const noChecksum = "none"
+const checksumFileFields = 2
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+var errChecksumFile = errors.New("invalid checksum file")
+
+// checksumAlgorithms are the hashes 'wrstat stat' can checksum its output with.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"md5":    md5.New,
+	"sha256": sha256.New,
+}
+
+// checkChecksumAlgorithm dies if the given algorithm, supplied to the given
+// flag, isn't one of our checksumAlgorithms or noChecksum.
+func checkChecksumAlgorithm(flag, algorithm string) {
+	if _, ok := checksumAlgorithms[algorithm]; ok || algorithm == noChecksum {
+		return
+	}
+
+	names := make([]string, 0, len(checksumAlgorithms))
+	for name := range checksumAlgorithms {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	die("%s must be one of %s or %s", flag, strings.Join(names, ", "), noChecksum)
+}
+
+// writeChecksumFiles writes a checksum file for each of the given paths, using
+// the given algorithm. The checksum file is named after the path with a
+// ".checksum" suffix, and contains the algorithm and hex encoded checksum.
+// Does nothing if the algorithm is noChecksum.
+func writeChecksumFiles(algorithm string, paths ...string) error {
+	if algorithm == noChecksum {
+		return nil
+	}
+
+	for _, path := range paths {
+		sum, err := checksumFile(path, checksumAlgorithms[algorithm])
+		if err != nil {
+			return err
+		}
+
+		if err = os.WriteFile(path+checksumSuffix, []byte(algorithm+" "+sum+"\n"), modeRW); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checksumFile returns the hex encoded checksum of the given file's contents
+// using a hash from the given func.
+func checksumFile(path string, newHash func() hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if errc := file.Close(); errc != nil {
+			warn("failed to close file: %s", errc)
+		}
+	}()
+
+	h := newHash()
+
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyChecksumFiles checks that each of the given paths matches the checksum
+// in its checksum file, if it has one. Dies on mismatch.
+func verifyChecksumFiles(paths []string) {
+	for _, path := range paths {
+		if err := verifyChecksumFile(path); err != nil {
+			die("failed to verify [%s]: %s", path, err)
+		}
+	}
+}
+
+// verifyChecksumFile checks that the given path matches the checksum in its
+// checksum file. Files without a checksum file are not checked.
+func verifyChecksumFile(path string) error {
+	data, err := os.ReadFile(path + checksumSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != checksumFileFields {
+		return errChecksumFile
+	}
+
+	newHash, ok := checksumAlgorithms[fields[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown algorithm %s", errChecksumFile, fields[0])
+	}
+
+	sum, err := checksumFile(path, newHash)
+	if err != nil {
+		return err
+	}
+
+	if sum != fields[1] {
+		return errChecksumMismatch
+	}
+
+	return nil
+}
@@ -27,6 +27,8 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -46,11 +48,23 @@ const pgzipWriterBlocksMultiplier = 2
 const combineStatsOutputFileBasename = "combine.stats.gz"
 const combineUserGroupOutputFileBasename = "combine.byusergroup.gz"
 const combineGroupOutputFileBasename = "combine.bygroup"
+const combineXattrOutputFileBasename = "combine.byxattr"
 const combineLogOutputFileBasename = "combine.log.gz"
 const numSummaryColumns = 2
 const groupSumCols = 2
 const userGroupSumCols = 3
+const xattrSumCols = 1
 const intBase = 10
+const exportFormatCSV = "csv"
+const exportFormatJSON = "json"
+const defaultCompressionLevel = 6
+
+// options for this cmd.
+var combineFormats []string
+var combineCanonicalRoots []string
+var combineVerify bool
+var combineCompressionLevel int
+var combineDedupeHardlinks bool
 
 // combineCmd represents the combine command.
 var combineCmd = &cobra.Command{
@@ -69,6 +83,46 @@ compressed and placed at the root of the output directory in a file called
 The same applies to the *.log files, being called 'combine.log.gz'.
 
 The *.bygroup files are merged but not compressed and called 'combine.bygroup'.
+Likewise, if 'wrstat stat --xattr' was used, the *.byxattr files are merged in
+to 'combine.byxattr'.
+
+Before being used, any of the above input files that have a checksum file
+written by 'wrstat stat' are checked against it, and this command fails if any
+don't match.
+
+If you supply --format (which can be given multiple times), the merged bygroup
+data is additionally written out in each of the given formats, in files called
+'combine.bygroup.[format]'. Valid formats are 'csv' (with a header line) and
+'json' (an array of objects with group, user, count and size keys).
+
+If the stats were gathered from a path that is an alias of another (eg. /nfs/x
+and /mnt/x are mounts of the same storage), you can supply --canonical_root
+/nfs/x=/mnt/x to have all paths under /nfs/x rewritten to be under /mnt/x
+instead, in both the stats and byusergroup output. The byusergroup counts are
+moved from the parent directories of the alias to the parent directories of the
+canonical root, so that data from different aliases merges in to a single tree.
+Parent directories of an alias that are left with no files are not output.
+You can supply --canonical_root multiple times to describe multiple aliases.
+
+If you supply --dedupe_hardlinks, regular files with more than one hard link
+only have their size counted once in 'combine.stats.gz': the lexically-first
+path of each inode (identified by its device and inode numbers, as recorded by
+'wrstat stat') keeps its size, and every other path to the same inode is given
+a size of 0. This changes the meaning of the size column from apparent usage to
+physical usage, so a hardlinked file's size will appear in only one of the
+directories it is linked from. The byusergroup and bygroup files are summarised
+per 'wrstat stat' job before combine sees them, so are not deduplicated and
+continue to report apparent usage. Finding the first paths requires reading the
+stats files twice, and holding the inodes of all hardlinked files in memory.
+
+The compressed outputs are compressed at the given --compression_level, from 0
+(no compression, just storing the data in gzip format) to 9 (best, but slowest,
+compression).
+
+By default, once 'combine.stats.gz' has been written it is verified by
+decompressing it and checking it has as many lines as were written to it, so
+that a truncated or corrupt output makes this command fail (and so be retried
+by wr). You can disable this with --verify=false.
 
 NB: only call this by adding it to wr with a dependency on the dependency group
 you supplied 'wrstat walk'.`,
@@ -77,53 +131,99 @@ you supplied 'wrstat walk'.`,
 			die("exactly 1 'wrstat walk' output directory must be supplied")
 		}
 
+		checkExportFormats("--format", combineFormats)
+
+		if combineCompressionLevel < pgzip.NoCompression || combineCompressionLevel > pgzip.BestCompression {
+			die("--compression_level must be between %d and %d", pgzip.NoCompression, pgzip.BestCompression)
+		}
+		aliases := parseCanonicalRoots(combineCanonicalRoots)
+
 		sourceDir, err := filepath.Abs(args[0])
 		if err != nil {
 			die("could not get the absolute path to [%s]: %s", args[0], err)
 		}
 
-		var wg sync.WaitGroup
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			concatenateAndCompressStatsFiles(sourceDir)
-		}()
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			mergeAndCompressUserGroupFiles(sourceDir)
-		}()
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			mergeGroupFiles(sourceDir)
-		}()
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			mergeAndCompressLogFiles(sourceDir)
-		}()
-
-		wg.Wait()
+		combineOutputs(sourceDir, aliases)
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(combineCmd)
+
+	// flags specific to this sub-command
+	combineCmd.Flags().StringSliceVar(&combineFormats, "format", nil,
+		"additional format(s) to output the bygroup data in (csv, json)")
+	combineCmd.Flags().StringArrayVar(&combineCanonicalRoots, "canonical_root", nil,
+		"/alias/path=/canonical/path to rewrite paths under an alias (can be repeated)")
+	combineCmd.Flags().BoolVar(&combineVerify, "verify", true, "verify the combined stats output")
+	combineCmd.Flags().IntVar(&combineCompressionLevel, "compression_level", defaultCompressionLevel,
+		"gzip compression level, from 0 (none) to 9 (best)")
+	combineCmd.Flags().BoolVar(&combineDedupeHardlinks, "dedupe_hardlinks", false,
+		"count the size of hardlinked files only once in the combined stats")
+}
+
+// combineOutputs does the main work of combining the 'wrstat stat' output files
+// in the given directory, rewriting paths using the given aliases.
+func combineOutputs(sourceDir string, aliases rootAliases) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		concatenateAndCompressStatsFiles(sourceDir, aliases)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mergeAndCompressUserGroupFiles(sourceDir, aliases)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mergeGroupFiles(sourceDir)
+		exportGroupSummary(sourceDir, combineFormats)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mergeXattrFiles(sourceDir)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mergeAndCompressLogFiles(sourceDir)
+	}()
+
+	wg.Wait()
 }
 
 // concatenateAndCompressStatsFiles finds and conatenates the stats files and
-// compresses the output.
-func concatenateAndCompressStatsFiles(sourceDir string) {
+// compresses the output. Paths are rewritten if any aliases are supplied, and
+// hardlinks are deduplicated if --dedupe_hardlinks is set. The output is
+// verified afterwards if --verify is set.
+func concatenateAndCompressStatsFiles(sourceDir string, aliases rootAliases) {
 	paths := findStatFilePaths(sourceDir)
+	verifyChecksumFiles(paths)
+
+	rewrite := statsLineRewriter(paths, aliases)
 	inputs := openFiles(paths)
 	output := createCombineStatsOutputFile(sourceDir)
 
-	concatenateAndCompress(inputs, output)
+	var lines int
+
+	if rewrite != nil {
+		lines = concatenateRewriteAndCompress(inputs, output, rewrite)
+	} else {
+		lines = concatenateAndCompress(inputs, output)
+	}
+
+	if combineVerify {
+		verifyCompressedLines(output.Name(), lines)
+	}
 }
 
 // findStatFilePaths returns files in the given dir named with a '.stats'
@@ -176,14 +276,16 @@ func createOutputFileInDir(dir, basename string) *os.File {
 }
 
 // concatenateAndCompress concatenates and compresses the inputs and stores in
-// the output.
-func concatenateAndCompress(inputs []*os.File, output *os.File) {
+// the output. Returns the number of lines written.
+func concatenateAndCompress(inputs []*os.File, output *os.File) int {
 	zw, closeOutput := compressOutput(output)
+	counter := &lineCounter{}
+	w := io.MultiWriter(zw, counter)
 
 	buf := make([]byte, bytesInMB)
 
 	for _, input := range inputs {
-		if _, err := io.CopyBuffer(zw, input, buf); err != nil {
+		if _, err := io.CopyBuffer(w, input, buf); err != nil {
 			die("failed to concatenate and compress: %s", err)
 		}
 
@@ -193,15 +295,74 @@ func concatenateAndCompress(inputs []*os.File, output *os.File) {
 	}
 
 	closeOutput()
+
+	return counter.lines
+}
+
+// statsLineRewriter returns a function that rewrites a .stats file line to
+// dedupe hardlinks (if --dedupe_hardlinks is set) and then canonicalise its
+// path using the given aliases. Returns nil if no rewriting is needed.
+func statsLineRewriter(paths []string, aliases rootAliases) func(string) string {
+	var owners hardlinkOwners
+	if combineDedupeHardlinks {
+		owners = findHardlinkOwners(paths)
+	}
+
+	switch {
+	case owners != nil && len(aliases) > 0:
+		return func(line string) string {
+			return aliases.rewriteStatsLine(owners.rewriteStatsLine(line))
+		}
+	case owners != nil:
+		return owners.rewriteStatsLine
+	case len(aliases) > 0:
+		return aliases.rewriteStatsLine
+	}
+
+	return nil
+}
+
+// concatenateRewriteAndCompress is like concatenateAndCompress, but rewrites
+// each line using the given function.
+func concatenateRewriteAndCompress(inputs []*os.File, output *os.File, rewrite func(string) string) int {
+	zw, closeOutput := compressOutput(output)
+	lines := 0
+
+	for _, input := range inputs {
+		scanner := bufio.NewScanner(input)
+
+		for scanner.Scan() {
+			if _, err := zw.Write([]byte(rewrite(scanner.Text()) + "\n")); err != nil {
+				die("failed to concatenate and compress: %s", err)
+			}
+
+			lines++
+		}
+
+		if err := scanner.Err(); err != nil {
+			die("failed to read a .stats file: %s", err)
+		}
+
+		if err := input.Close(); err != nil {
+			warn("failed to close an input file: %s", err)
+		}
+	}
+
+	closeOutput()
+
+	return lines
 }
 
-// compressOutput wraps the given output to compress data copied to it, and
-// returns the writer. Also returns a function that you should call to close
-// the writer and output when you're done.
+// compressOutput wraps the given output to compress data copied to it at our
+// --compression_level, and returns the writer. Also returns a function that
+// you should call to close the writer and output when you're done.
 func compressOutput(output *os.File) (*pgzip.Writer, func()) {
-	zw := pgzip.NewWriter(output)
+	zw, err := pgzip.NewWriterLevel(output, combineCompressionLevel)
+	if err != nil {
+		die("failed to set up compression: %s", err)
+	}
 
-	err := zw.SetConcurrency(bytesInMB, runtime.GOMAXPROCS(0)*pgzipWriterBlocksMultiplier)
+	err = zw.SetConcurrency(bytesInMB, runtime.GOMAXPROCS(0)*pgzipWriterBlocksMultiplier)
 	if err != nil {
 		die("failed to set up compression: %s", err)
 	}
@@ -220,12 +381,13 @@ func compressOutput(output *os.File) (*pgzip.Writer, func()) {
 }
 
 // mergeAndCompressUserGroupFiles finds and merges the byusergroup files and
-// compresses the output.
-func mergeAndCompressUserGroupFiles(sourceDir string) {
+// compresses the output. Directories are rewritten if any aliases are supplied.
+func mergeAndCompressUserGroupFiles(sourceDir string, aliases rootAliases) {
 	paths := findUserGroupFilePaths(sourceDir)
+	verifyChecksumFiles(paths)
 	output := createCombineUserGroupOutputFile(sourceDir)
 
-	err := mergeUserGroupAndCompress(paths, output)
+	err := mergeUserGroupAndCompress(paths, output, aliases)
 	if err != nil {
 		die("failed to merge the byusergroup files: %s", err)
 	}
@@ -244,8 +406,14 @@ func createCombineUserGroupOutputFile(dir string) *os.File {
 }
 
 // mergeUserGroupAndCompress merges the inputs and stores in the output,
-// compressed.
-func mergeUserGroupAndCompress(inputs []string, output *os.File) error {
+// compressed. Directories are rewritten if any aliases are supplied.
+func mergeUserGroupAndCompress(inputs []string, output *os.File, aliases rootAliases) error {
+	if len(aliases) > 0 {
+		return mergeFilesAndStreamToOutput(inputs, output, func(data io.ReadCloser, output *os.File) error {
+			return mergeCanonicalUserGroupStreamToCompressedFile(data, output, aliases)
+		})
+	}
+
 	return mergeFilesAndStreamToOutput(inputs, output, mergeUserGroupStreamToCompressedFile)
 }
 
@@ -328,6 +496,27 @@ func mergeUserGroupStreamToCompressedFile(data io.ReadCloser, output *os.File) e
 	return nil
 }
 
+// mergeCanonicalUserGroupStreamToCompressedFile is like
+// mergeUserGroupStreamToCompressedFile, but first rewrites the directories in
+// the data using the given aliases. Lines that sum to a file count of 0 (the
+// parents of an alias that only contained the alias) are not output.
+func mergeCanonicalUserGroupStreamToCompressedFile(data io.ReadCloser, output *os.File, aliases rootAliases) error {
+	canonical, cleanup, err := aliases.canonicaliseUserGroupStream(data)
+	if err != nil {
+		return err
+	}
+
+	zw, closeOutput := compressOutput(output)
+
+	if err = mergeSummaryLinesWith(canonical, userGroupSumCols, zw, writeNonEmptySummaryLine); err != nil {
+		return err
+	}
+
+	closeOutput()
+
+	return cleanup()
+}
+
 // mergeUserGroupStreamToOutput merges pre-sorted (pre-merged) usergroup data
 // (eg. from a `sort -m` of .byusergroup files), summing consecutive lines with
 // the first 3 columns, and outputting the results.
@@ -339,6 +528,13 @@ func mergeUserGroupStreamToOutput(data io.ReadCloser, output io.Writer) error {
 // `sort -m` of .by* files), summing consecutive lines that have the same values
 // in the first matchColumns columns, and outputting the results.
 func mergeSummaryLines(data io.ReadCloser, matchColumns int, output io.Writer) error {
+	return mergeSummaryLinesWith(data, matchColumns, output, writeSummaryLine)
+}
+
+// mergeSummaryLinesWith is like mergeSummaryLines, but uses the given function
+// to output each merged line.
+func mergeSummaryLinesWith(data io.ReadCloser, matchColumns int, output io.Writer,
+	write func([]string, io.Writer) error) error {
 	scanner := bufio.NewScanner(data)
 	previous := make([]string, matchColumns+numSummaryColumns)
 
@@ -352,7 +548,7 @@ func mergeSummaryLines(data io.ReadCloser, matchColumns int, output io.Writer) e
 		}
 
 		if previous[0] != "" {
-			if _, err := output.Write([]byte(strings.Join(previous, "\t") + "\n")); err != nil {
+			if err := write(previous, output); err != nil {
 				return err
 			}
 		}
@@ -360,11 +556,26 @@ func mergeSummaryLines(data io.ReadCloser, matchColumns int, output io.Writer) e
 		previous = current
 	}
 
-	_, err := output.Write([]byte(strings.Join(previous, "\t") + "\n"))
+	return write(previous, output)
+}
+
+// writeSummaryLine writes the given summary line columns to the output.
+func writeSummaryLine(cols []string, output io.Writer) error {
+	_, err := output.Write([]byte(strings.Join(cols, "\t") + "\n"))
 
 	return err
 }
 
+// writeNonEmptySummaryLine is like writeSummaryLine, but doesn't write lines
+// with a file count (the penultimate column) of 0.
+func writeNonEmptySummaryLine(cols []string, output io.Writer) error {
+	if cols[len(cols)-2] == "0" {
+		return nil
+	}
+
+	return writeSummaryLine(cols, output)
+}
+
 // summaryLinesMatch returns true if the first matchColumns elements of 'a'
 // match the first matchColums elements of 'b'.
 func summaryLinesMatch(matchColumns int, a, b []string) bool {
@@ -408,6 +619,7 @@ func atoi(n string) int64 {
 // mergeGroupFiles finds and merges the bygroup files.
 func mergeGroupFiles(sourceDir string) {
 	paths := findGroupFilePaths(sourceDir)
+	verifyChecksumFiles(paths)
 	output := createCombineGroupOutputFile(sourceDir)
 
 	err := mergeGroups(paths, output)
@@ -444,6 +656,37 @@ func mergeGroupStreamToFile(data io.ReadCloser, output *os.File) error {
 	return output.Close()
 }
 
+// mergeXattrFiles finds and merges the byxattr files, if there are any. Dies on
+// error.
+func mergeXattrFiles(sourceDir string) {
+	paths, err := filepath.Glob(fmt.Sprintf("%s/*%s", sourceDir, statXattrSummaryOutputFileSuffix))
+	if err != nil {
+		die("failed to find byxattr files: %s", err)
+	}
+
+	if len(paths) == 0 {
+		return
+	}
+
+	verifyChecksumFiles(paths)
+	output := createOutputFileInDir(sourceDir, combineXattrOutputFileBasename)
+
+	if err = mergeFilesAndStreamToOutput(paths, output, mergeXattrStreamToFile); err != nil {
+		die("failed to merge the byxattr files: %s", err)
+	}
+}
+
+// mergeXattrStreamToFile merges pre-sorted (pre-merged) xattr data (eg. from a
+// `sort -m` of .byxattr files), summing consecutive lines with the same first
+// column, and outputting the results.
+func mergeXattrStreamToFile(data io.ReadCloser, output *os.File) error {
+	if err := mergeSummaryLines(data, xattrSumCols, output); err != nil {
+		return err
+	}
+
+	return output.Close()
+}
+
 // mergeAndCompressLogFiles finds and merges the log files and compresses the
 // output.
 func mergeAndCompressLogFiles(sourceDir string) {
@@ -485,3 +728,123 @@ func mergeLogStreamToCompressedFile(data io.ReadCloser, output *os.File) error {
 
 	return nil
 }
+
+// groupSummaryRow holds the data from one line of a bygroup file.
+type groupSummaryRow struct {
+	Group string `json:"group"`
+	User  string `json:"user"`
+	Count int64  `json:"count"`
+	Size  int64  `json:"size"`
+}
+
+// groupSummaryExporter is a function that writes groupSummaryRows to an output
+// in a particular format.
+type groupSummaryExporter func(rows []*groupSummaryRow, output io.Writer) error
+
+// groupSummaryExporters are our groupSummaryExporter functions, keyed on the
+// format they write.
+var groupSummaryExporters = map[string]groupSummaryExporter{
+	exportFormatCSV:  exportGroupSummaryCSV,
+	exportFormatJSON: exportGroupSummaryJSON,
+}
+
+// checkExportFormats dies if any of the given formats (supplied to the given
+// flag) aren't ones we can export in.
+func checkExportFormats(flag string, formats []string) {
+	for _, format := range formats {
+		if _, ok := groupSummaryExporters[format]; !ok {
+			die("%s must be one of '%s' or '%s', not '%s'", flag, exportFormatCSV, exportFormatJSON, format)
+		}
+	}
+}
+
+// exportGroupSummary reads the combine.bygroup file in the given dir and writes
+// its data to a combine.bygroup.[format] file for each of the given formats.
+// Dies on error.
+func exportGroupSummary(sourceDir string, formats []string) {
+	if len(formats) == 0 {
+		return
+	}
+
+	rows, err := readGroupSummary(filepath.Join(sourceDir, combineGroupOutputFileBasename))
+	if err != nil {
+		die("failed to read the combined bygroup file: %s", err)
+	}
+
+	for _, format := range formats {
+		output := createOutputFileInDir(sourceDir, combineGroupOutputFileBasename+"."+format)
+
+		if err = groupSummaryExporters[format](rows, output); err != nil {
+			die("failed to export the bygroup data as %s: %s", format, err)
+		}
+
+		if err = output.Close(); err != nil {
+			die("failed to close output file: %s", err)
+		}
+	}
+}
+
+// readGroupSummary parses the given bygroup file.
+func readGroupSummary(path string) ([]*groupSummaryRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err = file.Close(); err != nil {
+			warn("failed to close bygroup file: %s", err)
+		}
+	}()
+
+	var rows []*groupSummaryRow
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) != groupSumCols+numSummaryColumns {
+			continue
+		}
+
+		rows = append(rows, &groupSummaryRow{
+			Group: cols[0],
+			User:  cols[1],
+			Count: atoi(cols[2]),
+			Size:  atoi(cols[3]),
+		})
+	}
+
+	return rows, scanner.Err()
+}
+
+// exportGroupSummaryCSV is a groupSummaryExporter that writes CSV with a header
+// line.
+func exportGroupSummaryCSV(rows []*groupSummaryRow, output io.Writer) error {
+	w := csv.NewWriter(output)
+
+	if err := w.Write([]string{"group", "user", "count", "size"}); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if err := w.Write([]string{row.Group, row.User,
+			strconv.FormatInt(row.Count, intBase), strconv.FormatInt(row.Size, intBase)}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+// exportGroupSummaryJSON is a groupSummaryExporter that writes a JSON array of
+// objects.
+func exportGroupSummaryJSON(rows []*groupSummaryRow, output io.Writer) error {
+	if rows == nil {
+		rows = []*groupSummaryRow{}
+	}
+
+	return json.NewEncoder(output).Encode(rows)
+}
@@ -0,0 +1,77 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportGroupSummary(t *testing.T) {
+	Convey("Given a combine.bygroup file in a multi working directory", t, func() {
+		tmp := t.TempDir()
+		multiUnique := filepath.Join(tmp, "multi")
+		sourceDir := filepath.Join(multiUnique, "interest", "unique")
+		destDir := filepath.Join(tmp, "final")
+
+		So(os.MkdirAll(sourceDir, userOnlyPerm), ShouldBeNil)
+		So(os.Mkdir(destDir, userOnlyPerm), ShouldBeNil)
+
+		err := os.WriteFile(filepath.Join(sourceDir, combineGroupOutputFileBasename),
+			[]byte("g1\tu1\t2\t10\ng2\tu2\t1\t5\n"), userOnlyPerm)
+		So(err, ShouldBeNil)
+
+		Convey("Exported csv and json files are moved to the final directory by tidy", func() {
+			exportGroupSummary(sourceDir, []string{exportFormatCSV, exportFormatJSON})
+
+			destDirInfo, err := os.Stat(destDir)
+			So(err, ShouldBeNil)
+
+			So(moveAndDelete(multiUnique, destDir, destDirInfo, "20220101"), ShouldBeNil)
+
+			prefix := filepath.Join(destDir, "20220101_interest.unique.multi.bygroup")
+
+			content, err := os.ReadFile(prefix + ".csv")
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "group,user,count,size\ng1,u1,2,10\ng2,u2,1,5\n")
+
+			content, err = os.ReadFile(prefix + ".json")
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, `[{"group":"g1","user":"u1","count":2,"size":10},`+
+				`{"group":"g2","user":"u2","count":1,"size":5}]`+"\n")
+
+			content, err = os.ReadFile(prefix)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "g1\tu1\t2\t10\ng2\tu2\t1\t5\n")
+
+			_, err = os.Stat(multiUnique)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,123 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"os"
+	"strings"
+)
+
+// column indexes of the .stats file lines written by 'wrstat stat'.
+const (
+	statsColPath  = 0
+	statsColSize  = 1
+	statsColType  = 7
+	statsColIno   = 8
+	statsColNlink = 9
+	statsColDev   = 10
+)
+
+// hardlinkOwners holds the lexically-first path of each hardlinked regular
+// file, keyed on its device and inode numbers.
+type hardlinkOwners map[string]string
+
+// findHardlinkOwners reads the given .stats files and returns the
+// hardlinkOwners of the hardlinked files in them. Dies on error.
+func findHardlinkOwners(paths []string) hardlinkOwners {
+	owners := make(hardlinkOwners)
+
+	for _, path := range paths {
+		owners.addFromFile(path)
+	}
+
+	return owners
+}
+
+// addFromFile adds the hardlinked files in the given .stats file to our
+// owners, replacing existing owners with lexically earlier paths. Dies on
+// error.
+func (h hardlinkOwners) addFromFile(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		die("failed to open a .stats file: %s", err)
+	}
+
+	defer func() {
+		if err = file.Close(); err != nil {
+			warn("failed to close an input file: %s", err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		key, path, ok := hardlinkedFile(strings.Split(scanner.Text(), "\t"))
+		if !ok {
+			continue
+		}
+
+		if owner, exists := h[key]; !exists || path < owner {
+			h[key] = path
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		die("failed to read a .stats file: %s", err)
+	}
+}
+
+// rewriteStatsLine returns the given .stats file line with its size set to 0
+// if it is for a hardlinked file that isn't the owner of its inode.
+func (h hardlinkOwners) rewriteStatsLine(line string) string {
+	cols := strings.Split(line, "\t")
+
+	key, path, ok := hardlinkedFile(cols)
+	if !ok || h[key] == path {
+		return line
+	}
+
+	cols[statsColSize] = "0"
+
+	return strings.Join(cols, "\t")
+}
+
+// hardlinkedFile returns a device:inode key and the decoded path of the given
+// .stats file line columns, if they are for a regular file with more than 1
+// hard link. Otherwise returns false. Lines with extra columns are accepted.
+func hardlinkedFile(cols []string) (string, string, bool) {
+	if len(cols) <= statsColDev || cols[statsColType] != "f" || cols[statsColNlink] == "1" {
+		return "", "", false
+	}
+
+	path, err := base64.StdEncoding.DecodeString(cols[statsColPath])
+	if err != nil {
+		die("bad base64 path in stats line: %s", err)
+	}
+
+	return cols[statsColDev] + ":" + cols[statsColIno], string(path), true
+}
@@ -0,0 +1,147 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/wtsi-ssg/wrstat/walk"
+)
+
+// options for this cmd.
+var localOutputDir string
+var localJobs int
+var localCh string
+
+// localCmd represents the local command.
+var localCmd = &cobra.Command{
+	Use:   "local",
+	Short: "Get stats on a directory tree without using wr",
+	Long: `Get stats on a directory tree without using wr.
+
+This does the same work as 'wrstat walk', 'wrstat stat' and 'wrstat combine' on
+the given directory of interest, but runs everything in this process instead of
+adding jobs to wr's queue, so does not need a wr manager to be running. This is
+suitable for small directory trees, and for trying out wrstat.
+
+The directory is walked to create --jobs output files in the given
+--output_directory, which are then stat'd in parallel, and finally the results
+are combined. The -o directory ends up containing the same files as the
+directory you would supply to 'wrstat combine', including the final
+'combine.stats.gz', 'combine.byusergroup.gz', 'combine.bygroup' and
+'combine.log.gz' files.
+
+If you supply the --ch or --xattr options, they are used the same way as for
+'wrstat stat'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if localOutputDir == "" {
+			die("--output_directory is required")
+		}
+
+		if len(args) != 1 {
+			die("exactly 1 directory of interest must be supplied")
+		}
+
+		if localJobs < 1 {
+			die("--jobs must be at least 1")
+		}
+
+		desiredDir, err := filepath.Abs(args[0])
+		if err != nil {
+			die("could not get the absolute path to [%s]: %s", args[0], err)
+		}
+
+		outDir, err := filepath.Abs(localOutputDir)
+		if err != nil {
+			die("could not get the absolute path to [%s]: %s", localOutputDir, err)
+		}
+
+		if err = os.MkdirAll(outDir, userOnlyPerm); err != nil {
+			die("failed to create the output directory: %s", err)
+		}
+
+		logToFile(filepath.Join(outDir, walkLogOutputBasename))
+
+		statFilesLocally(walkDirLocally(desiredDir, outDir, localJobs), localCh)
+		combineOutputs(outDir, nil)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(localCmd)
+
+	// flags specific to this sub-command
+	localCmd.Flags().StringVarP(&localOutputDir, "output_directory", "o", "", "base directory for output files")
+	localCmd.Flags().IntVarP(&localJobs, "jobs", "j", runtime.NumCPU(), "number of files to stat in parallel")
+	localCmd.Flags().StringVar(&localCh, "ch", "", "YAML file detailing paths to chmod & chown")
+	localCmd.Flags().StringVar(&statXattr, "xattr", "", "extended attribute to additionally summarise on")
+}
+
+// walkDirLocally walks the given dir, writing the paths found to n files in
+// outDir, and returns the paths to those files. Dies on error.
+func walkDirLocally(dir, outDir string, n int) []string {
+	walker, err := walk.New(outDir, n)
+	if err != nil {
+		die("failed to create walk output files: %s", err)
+	}
+
+	counter := &walkErrorCounter{}
+
+	if err = walker.Walk(dir, counter.callback); err != nil {
+		die("failed to walk the filesystem: %s", err)
+	}
+
+	counter.summarise()
+
+	if err = walker.Close(); err != nil {
+		die("failed to close walk output files: %s", err)
+	}
+
+	return walker.OutputPaths()
+}
+
+// statFilesLocally does the work of 'wrstat stat' on each of the given files in
+// parallel, passing through yamlPath as --ch.
+func statFilesLocally(paths []string, yamlPath string) {
+	var wg sync.WaitGroup
+
+	for _, path := range paths {
+		wg.Add(1)
+
+		go func(path string) {
+			defer wg.Done()
+
+			statPathsInFile(path, yamlPath, false)
+			writeStatChecksums(statOutputPrefix(path), statChecksum)
+		}(path)
+	}
+
+	wg.Wait()
+}
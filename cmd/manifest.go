@@ -0,0 +1,72 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/wtsi-ssg/wrstat/walk"
+)
+
+const walkManifestBasename = "walk.manifest.json"
+
+// walkManifestEntry describes one of the output files of a walk.
+type walkManifestEntry struct {
+	Path  string `json:"path"`
+	Lines int    `json:"lines"`
+	Bytes int64  `json:"bytes"`
+}
+
+// writeWalkManifest writes a walk.manifest.json file to the given output
+// directory, describing the output files of the given walker, which should
+// have been closed. Returns the entries written. Dies on error.
+func writeWalkManifest(outputDir string, walker *walk.Walker) []*walkManifestEntry {
+	paths := walker.OutputPaths()
+	counts := walker.OutputLineCounts()
+	entries := make([]*walkManifestEntry, len(paths))
+
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			die("failed to stat walk output file: %s", err)
+		}
+
+		entries[i] = &walkManifestEntry{Path: path, Lines: counts[i], Bytes: info.Size()}
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		die("failed to encode the walk manifest: %s", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(outputDir, walkManifestBasename), append(data, '\n'), modeRW); err != nil {
+		die("failed to write the walk manifest: %s", err)
+	}
+
+	return entries
+}
@@ -29,6 +29,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/VertebrateResequencing/wr/jobqueue"
@@ -48,6 +49,9 @@ var workDir string
 var finalDir string
 var multiInodes int
 var multiCh string
+var multiFinalFormats []string
+var multiDirsFile string
+var multiDryRun bool
 
 // multiCmd represents the multi command.
 var multiCmd = &cobra.Command{
@@ -67,6 +71,9 @@ This calls 'wrstat walk' and 'wrstat combine' on each of the given directories
 of interest. Their outputs go to a unique subdirectory of the given
 --working_directory, which means you can start running this before a previous
 run has completed on the same inputs, and there won't be conflicts.
+As well as supplying directories of interest as arguments, you can list them in
+a file supplied to --dirs_file, one per line (blank lines and lines starting
+with # are ignored). Directories given more than once are only done once.
 It is best if all your directories of interest have different basenames, but
 things will still work and not conflict if they don't. To ensure this, the
 output directory for each directory of interest is a unique subdirectory of the
@@ -97,11 +104,23 @@ It might produce:
 /path/b/20210617_bar.d498vhsk39fjh129djg8.c35m8359bnc8ni7dgphg.logs.gz
 /path/b/20210617_bar.d498vhsk39fjh129djg8.c35m8359bnc8ni7dgphg.stats.gz
 
+If you supply --final_format (which can be given multiple times) with 'csv'
+and/or 'json', the bygroup data will also be output in those formats, giving
+additional files named like the above, but with suffixes 'bygroup.csv' and
+'bygroup.json'. Likewise, if you supply --xattr, there will also be a summary of
+the values of that extended attribute in a file with the suffix 'byxattr'.
+
 The output files will be given the same user:group ownership and
 user,group,other read & write permissions as the --final_output directory.
 
 Finally, the unique subdirectory of --working_directory that was created is
-deleted.`,
+deleted. To avoid overloading the filesystem when many multi runs finish at the
+same time, --delete_jitter and --delete_rate can be used to spread out the
+deletions; see 'wrstat tidy -h'.
+
+With --dry_run, wr manager doesn't need to be running: no working directory is
+created, and the details of the jobs that would have been added to wr's queue
+are printed instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if workDir == "" {
 			die("--working_directory is required")
@@ -109,21 +128,25 @@ deleted.`,
 		if finalDir == "" {
 			die("--final_output is required")
 		}
+		args = directoriesOfInterest(args, multiDirsFile)
 		if len(args) == 0 {
 			die("at least 1 directory of interest must be supplied")
 		}
 
-		s, d := newScheduler(workDir)
+		checkExportFormats("--final_format", multiFinalFormats)
+
+		s, d := newScheduler(workDir, multiDryRun)
 		defer d()
 
 		unique := scheduler.UniqueString()
 		outputRoot := filepath.Join(workDir, unique)
-		err := os.MkdirAll(outputRoot, userOnlyPerm)
-		if err != nil {
-			die("failed to create working dir: %s", err)
+		if !multiDryRun {
+			if err := os.MkdirAll(outputRoot, userOnlyPerm); err != nil {
+				die("failed to create working dir: %s", err)
+			}
 		}
 
-		scheduleWalkJobs(outputRoot, args, unique, multiInodes, multiCh, s)
+		scheduleWalkJobs(outputRoot, args, unique, multiInodes, multiCh, multiFinalFormats, s)
 		scheduleTidyJob(outputRoot, finalDir, unique, s)
 	},
 }
@@ -137,41 +160,128 @@ func init() {
 	multiCmd.Flags().IntVarP(&multiInodes, "inodes_per_stat", "n",
 		defaultInodesPerJob, "number of inodes per parallel stat job")
 	multiCmd.Flags().StringVar(&multiCh, "ch", "", "passed through to 'wrstat walk'")
+	multiCmd.Flags().StringVar(&statXattr, "xattr", "", "passed through to 'wrstat walk'")
+	multiCmd.Flags().IntVar(&walkMaxOpenFiles, "max_open_files", 0, "passed through to 'wrstat walk'")
+	multiCmd.Flags().StringSliceVar(&multiFinalFormats, "final_format", nil,
+		"additional format(s) to output the bygroup data in (csv, json)")
+	multiCmd.Flags().StringVar(&multiDirsFile, "dirs_file", "",
+		"file containing directories of interest, one per line")
+	multiCmd.Flags().BoolVar(&multiDryRun, "dry_run", false,
+		"print the jobs that would be added to wr's queue instead of adding them")
+	addDeleteFlags(multiCmd, "; passed through to 'wrstat tidy'")
+	addManagerFlags(multiCmd)
+}
+
+// directoriesOfInterest returns the given dirs along with those listed in the
+// given dirsFile (if not blank), without duplicates. Dies if the file can't be
+// read.
+func directoriesOfInterest(dirs []string, dirsFile string) []string {
+	if dirsFile != "" {
+		dirs = append(dirs, readDirsFile(dirsFile)...)
+	}
+
+	seen := make(map[string]bool, len(dirs))
+	unique := make([]string, 0, len(dirs))
+
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+
+		seen[dir] = true
+		unique = append(unique, dir)
+	}
+
+	return unique
+}
+
+// readDirsFile returns the directories listed in the given file, ignoring
+// comment lines starting with #. Dies if the file can't be read.
+func readDirsFile(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		die("could not open --dirs_file: %s", err)
+	}
+
+	var dirs []string
+
+	for _, line := range readLines(file) {
+		if !strings.HasPrefix(line, "#") {
+			dirs = append(dirs, line)
+		}
+	}
+
+	if err = file.Close(); err != nil {
+		warn("failed to close --dirs_file: %s", err)
+	}
+
+	return dirs
 }
 
 // scheduleWalkJobs adds a 'wrstat walk' job to wr's queue for each desired
 // path. The second scheduler is used to add combine jobs, which need a memory
-// override.
+// override. The given formats are passed through to combine's --format.
 func scheduleWalkJobs(outputRoot string, desiredPaths []string, unique string,
-	n int, yamlPath string, s *scheduler.Scheduler) {
-	walkJobs := make([]*jobqueue.Job, len(desiredPaths))
-	combineJobs := make([]*jobqueue.Job, len(desiredPaths))
+	n int, yamlPath string, formats []string, s *scheduler.Scheduler) {
+	var walkJobs, combineJobs []*jobqueue.Job
+
+	cmdWalk := walkCommand(s.Executable(), n, yamlPath)
+	cmdCombine := combineCommand(s.Executable(), formats)
+
+	reqWalk, reqCombine := reqs()
 
-	cmd := fmt.Sprintf("%s walk -n %d ", s.Executable(), n)
+	for _, path := range desiredPaths {
+		thisUnique := scheduler.UniqueString()
+		outDir := filepath.Join(outputRoot, filepath.Base(path), thisUnique)
+
+		walkJobs = append(walkJobs, s.NewJob(fmt.Sprintf("%s -d %s -o %s -i %s %s",
+			cmdWalk, thisUnique, outDir, statRepGrp(path, unique), path),
+			walkRepGrp(path, unique), "wrstat-walk", thisUnique, "", reqWalk))
+
+		combineJobs = append(combineJobs, s.NewJob(cmdCombine+outDir,
+			combineRepGrp(path, unique), "wrstat-combine", unique, thisUnique, reqCombine))
+	}
+
+	addJobsToQueue(s, walkJobs)
+	addJobsToQueue(s, combineJobs)
+}
+
+// walkCommand returns the start of a 'wrstat walk' command line using the
+// given exe, passing through n as --inodes_per_stat, the given yamlPath as --ch
+// if not blank, --xattr and --max_open_files if supplied, our --connect_timeout
+// and --submit_attempts, and --sudo if we're using sudo.
+func walkCommand(exe string, n int, yamlPath string) string {
+	cmd := fmt.Sprintf("%s walk -n %d --connect_timeout %s --submit_attempts %d ",
+		exe, n, connectTimeout, submitAttempts)
 	if yamlPath != "" {
 		cmd += fmt.Sprintf("--ch %s ", yamlPath)
 	}
 
+	if statXattr != "" {
+		cmd += fmt.Sprintf("--xattr %s ", statXattr)
+	}
+
+	if walkMaxOpenFiles > 0 {
+		cmd += fmt.Sprintf("--max_open_files %d ", walkMaxOpenFiles)
+	}
+
 	if sudo {
 		cmd += "--sudo "
 	}
 
-	reqWalk, reqCombine := reqs()
-
-	for i, path := range desiredPaths {
-		thisUnique := scheduler.UniqueString()
-		outDir := filepath.Join(outputRoot, filepath.Base(path), thisUnique)
+	return cmd
+}
 
-		walkJobs[i] = s.NewJob(fmt.Sprintf("%s -d %s -o %s -i %s %s",
-			cmd, thisUnique, outDir, statRepGrp(path, unique), path),
-			walkRepGrp(path, unique), "wrstat-walk", thisUnique, "", reqWalk)
+// combineCommand returns the start of a 'wrstat combine' command line using the
+// given exe, with a --format for each of the given formats.
+func combineCommand(exe string, formats []string) string {
+	cmd := fmt.Sprintf("%s combine ", exe)
 
-		combineJobs[i] = s.NewJob(fmt.Sprintf("%s combine %s", s.Executable(), outDir),
-			combineRepGrp(path, unique), "wrstat-combine", unique, thisUnique, reqCombine)
+	for _, format := range formats {
+		cmd += fmt.Sprintf("--format %s ", format)
 	}
 
-	addJobsToQueue(s, walkJobs)
-	addJobsToQueue(s, combineJobs)
+	return cmd
 }
 
 // reqs returns Requirements suitable for walk and combine jobs.
@@ -198,12 +308,28 @@ func combineRepGrp(dir, unique string) string {
 	return repGrp("combine", dir, unique)
 }
 
+// tidyDeleteArgs returns the --delete_jitter and --delete_rate args to pass to
+// 'wrstat tidy', or a blank string if neither was set.
+func tidyDeleteArgs() string {
+	var args string
+
+	if tidyDeleteJitter > 0 {
+		args += " --delete_jitter " + tidyDeleteJitter.String()
+	}
+
+	if tidyDeleteRate > 0 {
+		args += fmt.Sprintf(" --delete_rate %d", tidyDeleteRate)
+	}
+
+	return args
+}
+
 // scheduleTidyJob adds a job to wr's queue that for each working directory
 // subdir moves the output to the final location and then deletes the working
 // directory.
 func scheduleTidyJob(outputRoot, finalDir, unique string, s *scheduler.Scheduler) {
-	job := s.NewJob(fmt.Sprintf("%s tidy -f %s -d %s %s", s.Executable(), finalDir, dateStamp(), outputRoot),
-		repGrp("tidy", finalDir, unique), "wrstat-tidy", "", unique, nil)
+	job := s.NewJob(fmt.Sprintf("%s tidy -f %s -d %s%s %s", s.Executable(), finalDir, dateStamp(),
+		tidyDeleteArgs(), outputRoot), repGrp("tidy", finalDir, unique), "wrstat-tidy", "", unique, nil)
 
 	addJobsToQueue(s, []*jobqueue.Job{job})
 }
@@ -0,0 +1,81 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// dirHandlesPerWalkWorker is how many directories we allow for each walk worker
+// having open at once, while walking nested directories.
+const dirHandlesPerWalkWorker = 32
+
+var walkMaxOpenFiles int
+
+// checkOutputFilesWithinLimit dies if opening n output files wouldn't leave
+// room within --max_open_files for walking directories.
+func checkOutputFilesWithinLimit(n int) {
+	if walkMaxOpenFiles <= 0 || n+dirHandlesPerWalkWorker <= walkMaxOpenFiles {
+		return
+	}
+
+	die("walking would need %d output files, too many for --max_open_files %d; "+
+		"increase --inodes_per_stat, or --max_open_files (checking your 'ulimit -n')", n, walkMaxOpenFiles)
+}
+
+// walkWorkersWithinLimit returns --walk_workers, reduced if necessary (with the
+// default of 0, meaning unlimited, becoming limited), so that the given number
+// of open output files plus the directories each worker has open stays within
+// --max_open_files. Always returns at least 1 if --max_open_files is set.
+func walkWorkersWithinLimit(outputs int) int {
+	if walkMaxOpenFiles <= 0 {
+		return walkWorkers
+	}
+
+	budget := (walkMaxOpenFiles - outputs) / dirHandlesPerWalkWorker
+	if budget < 1 {
+		budget = 1
+	}
+
+	if walkWorkers == 0 || walkWorkers > budget {
+		return budget
+	}
+
+	return walkWorkers
+}
+
+// openFilesHint returns advice to append to an error message if the given error
+// is due to there being too many open files. Otherwise returns a blank string.
+func openFilesHint(err error) string {
+	if !errors.Is(err, syscall.EMFILE) && !errors.Is(err, syscall.ENFILE) {
+		return ""
+	}
+
+	return fmt.Sprintf(" (too many open files: set --max_open_files [currently %d] below your 'ulimit -n', "+
+		"or raise that limit)", walkMaxOpenFiles)
+}
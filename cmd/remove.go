@@ -0,0 +1,114 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// removeWorkingDir deletes dir and everything in it, like os.RemoveAll(). If
+// jitter is greater than 0, first waits for a random duration up to jitter. If
+// rate is greater than 0, removes no more than rate files and directories per
+// second. It is not an error if dir (or anything in it) doesn't exist.
+func removeWorkingDir(dir string, jitter time.Duration, rate int) error {
+	if jitter > 0 {
+		r := rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec
+
+		time.Sleep(time.Duration(r.Int63n(int64(jitter))))
+	}
+
+	if rate <= 0 {
+		return os.RemoveAll(dir)
+	}
+
+	ticker := time.NewTicker(removeInterval(rate))
+	defer ticker.Stop()
+
+	r := &rateLimitedRemover{tick: ticker.C}
+
+	return r.removeAll(dir)
+}
+
+// removeInterval returns the interval between removals for the given positive
+// rate per second, which is at least 1ns for rates too high to represent.
+func removeInterval(rate int) time.Duration {
+	interval := time.Second / time.Duration(rate)
+	if interval <= 0 {
+		interval = 1
+	}
+
+	return interval
+}
+
+// rateLimitedRemover removes paths, waiting for a tick before each removal.
+type rateLimitedRemover struct {
+	tick <-chan time.Time
+}
+
+// removeAll removes the contents of the given directory depth first, then the
+// directory itself.
+func (r *rateLimitedRemover) removeAll(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			err = r.removeAll(path)
+		} else {
+			err = r.remove(path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.remove(dir)
+}
+
+// remove waits for our next tick, then removes the given file or empty
+// directory.
+func (r *rateLimitedRemover) remove(path string) error {
+	<-r.tick
+
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
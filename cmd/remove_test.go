@@ -0,0 +1,88 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRemoveWorkingDir(t *testing.T) {
+	Convey("Given a working directory with nested contents", t, func() {
+		dir := filepath.Join(t.TempDir(), "work")
+		So(os.MkdirAll(filepath.Join(dir, "a", "b"), userOnlyPerm), ShouldBeNil)
+
+		for _, path := range []string{"1", "a/2", "a/b/3", "a/b/4"} {
+			So(os.WriteFile(filepath.Join(dir, path), []byte("x"), userOnlyPerm), ShouldBeNil)
+		}
+
+		Convey("It can be removed without limits", func() {
+			So(removeWorkingDir(dir, 0, 0), ShouldBeNil)
+			So(dir, shouldNotExist)
+		})
+
+		Convey("It can be removed at a limited rate", func() {
+			start := time.Now()
+			So(removeWorkingDir(dir, 0, 20), ShouldBeNil)
+			So(dir, shouldNotExist)
+
+			// 4 files and 3 dirs at 20 per second
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 300*time.Millisecond)
+		})
+
+		Convey("Rates too high for a ticker interval don't panic", func() {
+			So(removeInterval(2e9), ShouldEqual, time.Duration(1))
+			So(removeWorkingDir(dir, 0, 2e9), ShouldBeNil)
+			So(dir, shouldNotExist)
+		})
+
+		Convey("It can be removed after a random delay", func() {
+			start := time.Now()
+			So(removeWorkingDir(dir, 50*time.Millisecond, 0), ShouldBeNil)
+			So(dir, shouldNotExist)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		})
+
+		Convey("Removing it again is not an error", func() {
+			So(removeWorkingDir(dir, 0, 0), ShouldBeNil)
+			So(removeWorkingDir(dir, 0, 0), ShouldBeNil)
+			So(removeWorkingDir(dir, 0, 10), ShouldBeNil)
+		})
+	})
+}
+
+// shouldNotExist is a goconvey assertion that the given path doesn't exist.
+func shouldNotExist(actual interface{}, _ ...interface{}) string {
+	if _, err := os.Lstat(actual.(string)); err == nil { //nolint:forcetypeassert
+		return actual.(string) + " exists" //nolint:forcetypeassert
+	}
+
+	return ""
+}
@@ -29,6 +29,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -48,8 +49,21 @@ var appLogger = log15.New()
 // these variables are accessible by all subcommands.
 var deployment string
 var sudo bool
+var quiet bool
+var verbose bool
+var logFormat = logFormatText
 
-const connectTimeout = 10 * time.Second
+const logFormatText = "text"
+const logFormatJSON = "json"
+
+// connectTimeout and submitAttempts are set by commands that take the
+// addManagerFlags() flags.
+var connectTimeout = defaultConnectTimeout
+var submitAttempts = defaultSubmitAttempts
+
+const defaultConnectTimeout = 10 * time.Second
+const defaultSubmitAttempts = 5
+const submitInitialBackoff = 1 * time.Second
 
 // RootCmd represents the base command when called without any subcommands.
 var RootCmd = &cobra.Command{
@@ -69,6 +83,18 @@ If you need root to have permission to see all deseired files, either start wr
 manager as root, or start it as a user that can sudo without a password when
 running wrstat, and supply the --sudo option to wrstat sub commands.
 
+By default, warnings and informational messages are logged. Supply --quiet to
+only log errors, or --verbose to also log debug messages. If both are supplied,
+--verbose takes precedence. Errors that cause wrstat to exit are always logged.
+These options also apply to the log files written by sub commands.
+
+Log messages are written as text by default (logfmt in log files). Supply
+--log_format json to instead have each one written as a JSON object with t
+(timestamp), lvl and msg keys, for easier ingestion in to log analysis systems.
+Messages about walking and scheduling jobs also have their details (such as
+paths, counts and errors) as additional keys; other messages only have them
+within msg.
+
 For raw stats on a directory and all its sub contents:
 $ wrstat walk -o [/output/location] -d [dependency_group] [/location/of/interest]
 
@@ -77,7 +103,11 @@ $ wrstat combine [/output/location]
 
 Or more easily work on multiple locations of interest at once by doing the
 above 2 steps on each location and moving the final results to a final location:
-$ wrstat multi -w [/working/directory] -f [/final/output/dir] [/a /b /c]`,
+$ wrstat multi -w [/working/directory] -f [/final/output/dir] [/a /b /c]
+
+For small directory trees you can instead do the walk, stat and combine steps in
+a single process, without needing wr at all:
+$ wrstat local -o [/output/location] [/location/of/interest]`,
 }
 
 // Execute adds all child commands to the root command and sets flags
@@ -90,8 +120,17 @@ func Execute() {
 }
 
 func init() {
-	// set up logging to stderr
-	appLogger.SetHandler(log15.LvlFilterHandler(log15.LvlInfo, log15.StderrHandler))
+	// set up logging to stderr, at the level and in the format set by our
+	// flags once parsed
+	setLogHandler(log15.StderrHandler)
+
+	RootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if logFormat != logFormatText && logFormat != logFormatJSON {
+			die("--log_format must be one of '%s' or '%s', not '%s'", logFormatText, logFormatJSON, logFormat)
+		}
+
+		setLogHandler(stderrHandler())
+	}
 
 	// global flags
 	RootCmd.PersistentFlags().StringVar(&deployment,
@@ -103,17 +142,72 @@ func init() {
 		"sudo",
 		false,
 		"created jobs will run with sudo")
+
+	RootCmd.PersistentFlags().BoolVar(&quiet,
+		"quiet",
+		false,
+		"only log errors")
+
+	RootCmd.PersistentFlags().BoolVar(&verbose,
+		"verbose",
+		false,
+		"also log debug messages (takes precedence over --quiet)")
+
+	RootCmd.PersistentFlags().StringVar(&logFormat,
+		"log_format",
+		logFormatText,
+		"format of log messages (text, json)")
+}
+
+// logLevel returns the level we should log at, based on --quiet and
+// --verbose.
+func logLevel() log15.Lvl {
+	switch {
+	case verbose:
+		return log15.LvlDebug
+	case quiet:
+		return log15.LvlError
+	default:
+		return log15.LvlInfo
+	}
+}
+
+// logFormatter returns the log15.Format for our --log_format.
+func logFormatter() log15.Format {
+	if logFormat == logFormatJSON {
+		return log15.JsonFormat()
+	}
+
+	return log15.LogfmtFormat()
+}
+
+// stderrHandler returns a handler that logs to STDERR in our --log_format. For
+// text, this is log15's default handler, which uses colour on a terminal.
+func stderrHandler() log15.Handler {
+	if logFormat == logFormatJSON {
+		return log15.StreamHandler(os.Stderr, log15.JsonFormat())
+	}
+
+	return log15.StderrHandler
+}
+
+// setLogHandler makes appLogger log to the given handler, filtered to our
+// logLevel().
+func setLogHandler(h log15.Handler) {
+	appLogger.SetHandler(log15.LvlFilterHandler(logLevel(), h))
 }
 
+// logToFile makes appLogger log to the given file instead of STDERR, in our
+// --log_format.
 func logToFile(path string) {
-	fh, err := log15.FileHandler(path, log15.LogfmtFormat())
+	fh, err := log15.FileHandler(path, logFormatter())
 	if err != nil {
 		warn("Could not log to file [%s]: %s", path, err)
 
 		return
 	}
 
-	appLogger.SetHandler(fh)
+	setLogHandler(fh)
 }
 
 // warn is a convenience to log a message at the Warn level.
@@ -128,11 +222,25 @@ func die(msg string, a ...interface{}) {
 }
 
 // newScheduler returns a new Scheduler, exiting on error. It also returns a
-// function you should defer.
-func newScheduler(cwd string) (*scheduler.Scheduler, func()) {
-	s, err := scheduler.New(deployment, cwd, connectTimeout, appLogger, sudo)
-	if err != nil {
-		die("%s", err)
+// function you should defer. If dryRun is true, the Scheduler doesn't connect
+// to wr manager, and prints jobs to STDOUT instead of adding them to wr's
+// queue.
+func newScheduler(cwd string, dryRun bool) (*scheduler.Scheduler, func()) {
+	var s *scheduler.Scheduler
+
+	var err error
+
+	if dryRun {
+		s, err = scheduler.NewDryRun(cwd, os.Stdout, sudo)
+		if err != nil {
+			die("could not set up a dry run: %s", err)
+		}
+	} else {
+		s, err = scheduler.New(deployment, cwd, connectTimeout, appLogger, sudo)
+		if err != nil {
+			die("could not connect to wr manager: %s (check that 'wr manager' is running, and that wr's "+
+				"ManagerHost config option is set if it was started on a different host)", err)
+		}
 	}
 
 	return s, func() {
@@ -143,6 +251,16 @@ func newScheduler(cwd string) (*scheduler.Scheduler, func()) {
 	}
 }
 
+// addManagerFlags adds flags to the given command for configuring how we use wr
+// manager: --connect_timeout sets connectTimeout and --submit_attempts sets
+// submitAttempts.
+func addManagerFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&connectTimeout, "connect_timeout", defaultConnectTimeout,
+		"how long to wait when connecting to wr manager")
+	cmd.Flags().IntVar(&submitAttempts, "submit_attempts", defaultSubmitAttempts,
+		"how many times to try adding jobs to wr's queue before giving up")
+}
+
 // repGrp returns a rep_grp that can be used for a wrstat job we will create.
 func repGrp(cmd, dir, unique string) string {
 	return fmt.Sprintf("wrstat-%s-%s-%s-%s", cmd, filepath.Base(dir), dateStamp(), unique)
@@ -155,9 +273,34 @@ func dateStamp() string {
 	return t.Format("20060102")
 }
 
-// addJobsToQueue adds the jobs to wr's queue.
+// addJobsToQueue adds the jobs to wr's queue. Failures are retried with
+// exponential backoff, up to submitAttempts tries in total, after which we die.
+// Duplicate jobs are warned about.
 func addJobsToQueue(s *scheduler.Scheduler, jobs []*jobqueue.Job) {
-	if err := s.SubmitJobs(jobs); err != nil {
-		die("failed to add jobs to wr's queue: %s", err)
+	backoff := submitInitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		appLogger.Debug("adding jobs to wr's queue", "jobs", len(jobs), "attempt", attempt)
+
+		err := s.SubmitJobs(jobs)
+		if err == nil {
+			return
+		}
+
+		if errors.Is(err, scheduler.ErrDupJobs) {
+			appLogger.Warn("some jobs were already in wr's queue", "jobs", len(jobs), "err", err)
+
+			return
+		}
+
+		if attempt >= submitAttempts {
+			die("failed to add jobs to wr's queue: %s", err)
+		}
+
+		appLogger.Warn("failed to add jobs to wr's queue, will retry", "attempt", attempt,
+			"attempts", submitAttempts, "backoff", backoff, "err", err)
+
+		time.Sleep(backoff)
+		backoff *= 2
 	}
 }
@@ -26,8 +26,11 @@
 package cmd
 
 import (
+	"compress/gzip"
+	"io"
 	"io/fs"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -40,12 +43,21 @@ const reportFrequency = 10 * time.Minute
 const statOutputFileSuffix = ".stats"
 const statUserGroupSummaryOutputFileSuffix = ".byusergroup"
 const statGroupSummaryOutputFileSuffix = ".bygroup"
+const statXattrSummaryOutputFileSuffix = ".byxattr"
 const statLogOutputFileSuffix = ".log"
 const lstatTimeout = 10 * time.Second
 const lstatAttempts = 3
+const compressedInputSuffix = ".gz"
+const statStdinInput = "-"
 
 var statDebug bool
 var statCh string
+var statChecksum string
+var statXattr string
+var statTypedInput bool
+var statSkipSymlinks bool
+var statFilesOnly bool
+var statOutput string
 
 // statCmd represents the stat command.
 var statCmd = &cobra.Command{
@@ -55,9 +67,17 @@ var statCmd = &cobra.Command{
 
 Given a file containing an absolute file path per line (eg. as produced by
 'wrstat walk'), this creates a new file with stats for each of those file paths.
-The new file is named after the input file with a ".stats" suffix.
+The new file is named after the input file with a ".stats" suffix. If the input
+file is gzip compressed (eg. as produced by 'wrstat walk --compress'), it must
+have a ".gz" suffix, and it will be decompressed as it is read; output files are
+then named after the input file without its ".gz" suffix.
 
-The output file format is 11 tab separated columns with the following contents:
+Supply "-" as the input file to instead read paths from STDIN, eg. to pipe in
+the output of some other program that lists paths. In that case you must supply
+--output, and output files are named after that path instead. An empty STDIN
+results in empty (but valid) output files.
+
+The output file format is 12 tab separated columns with the following contents:
 1. Base64 encoded path to the file.
 2. File size in bytes. If this is greater than the number of bytes in blocks
    allocated, this will be the number of bytes in allocated blocks. (This is to
@@ -79,6 +99,9 @@ The output file format is 11 tab separated columns with the following contents:
 9. Inode number (on unix).
 10. Number of hard links.
 11. Identifier of the device on which this file resides.
+12. Disk usage in bytes: the number of 512 byte blocks allocated, multiplied by
+    512. This is what 'du' reports, and can differ from the size in column 2,
+    eg. for small files on filesystems with large blocks.
 
 It also summarises file count and size information by grouping on
 user+group+directory, and stores this summary in another file named after the
@@ -112,6 +135,17 @@ and stores this summary in another file named after the input file with a
 3. number of files belonging to both 1 & 2.
 4. total file size in bytes of the files in 3.
 
+If you supply the name of an extended attribute to --xattr (eg. user.project),
+it also summarises file count and size information by grouping on the value of
+that attribute, and stores this summary in another file named after the input
+file with a ".byxattr" suffix. This is 3 tab separated columns with the
+following contents (sorted on the first column):
+
+1. attribute value, with any tabs or newlines replaced by spaces, or "unset" for
+   files that don't have the attribute (and for symbolic links).
+2. number of files with value 1.
+3. total file size in bytes of the files in 2.
+
 If you supply a yaml file to --ch of the following format:
 prefixes: ["/disk1", "/disk2/sub", "/disk3"]
 lookupDir: subdir_name_of_prefixes_that_contains_subdirs_in_lookup
@@ -141,18 +175,44 @@ be ensured:
 (Any changes caused by this will not be reflected in the output file, since
 the chmod and chown operations happen after path's stats are retrieved.)
 
+If the input file was made with 'wrstat walk --emit_types', then you must
+supply --typed_input, so that each line is treated as a path, a tab, and the
+type of the path.
+
+With --skip_symlinks, symbolic links are ignored: they're not output and not
+included in any summaries. With --files_only, everything except regular files
+is ignored. With --typed_input, paths that will be ignored are not even
+lstat'd; otherwise they are lstat'd to determine their type.
+
+If there are at least 10 regular files and all of them have an atime equal to
+their mtime, a warning is logged that atimes (column 5) may be unreliable, since
+the filesystem may be mounted noatime.
+
 Finally, log messages (including things like warnings and errors while working
 on the above) are stored in another file named after the input file with a
 ".log" suffix.
+
+So that 'wrstat combine' can detect if they get corrupted, each of the .stats,
+.byusergroup, .bygroup and .byxattr output files has a checksum written to
+another file with the same name plus a ".checksum" suffix. The checksum
+algorithm is selected with --checksum (crc32, md5 or sha256), or supply "none"
+to not write checksum files.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 1 {
 			die("exactly 1 input file should be provided")
 		}
 
-		logToFile(args[0] + statLogOutputFileSuffix)
+		if args[0] == statStdinInput && statOutput == "" {
+			die("--output is required when reading from STDIN")
+		}
+
+		checkChecksumAlgorithm("--checksum", statChecksum)
+
+		logToFile(statOutputPrefix(args[0]) + statLogOutputFileSuffix)
 
 		statPathsInFile(args[0], statCh, statDebug)
+		writeStatChecksums(statOutputPrefix(args[0]), statChecksum)
 	},
 }
 
@@ -161,28 +221,95 @@ func init() {
 
 	statCmd.Flags().StringVar(&statCh, "ch", "", "YAML file detailing paths to chmod & chown")
 	statCmd.Flags().BoolVar(&statDebug, "debug", false, "output Lstat timings")
+	statCmd.Flags().StringVar(&statChecksum, "checksum", defaultChecksumAlgorithm,
+		"algorithm to checksum output files with (crc32, md5, sha256 or none)")
+	statCmd.Flags().StringVar(&statXattr, "xattr", "", "extended attribute to additionally summarise on")
+	statCmd.Flags().BoolVar(&statTypedInput, "typed_input", false,
+		"input lines are a path, tab and type (from 'wrstat walk --emit_types')")
+	statCmd.Flags().BoolVar(&statSkipSymlinks, "skip_symlinks", false, "ignore symbolic links")
+	statCmd.Flags().BoolVar(&statFilesOnly, "files_only", false, "ignore everything except regular files")
+	statCmd.Flags().StringVarP(&statOutput, "output", "o", "",
+		"path to name output files after when reading from STDIN")
 }
 
 // statPathsInFile does the main work.
 func statPathsInFile(inputPath string, yamlPath string, debug bool) {
-	input, err := os.Open(inputPath)
-	if err != nil {
-		die("failed to open input file: %s", err)
-	}
+	input := openStatInput(inputPath)
 
 	defer func() {
-		err = input.Close()
-		if err != nil {
+		if err := input.Close(); err != nil && input != os.Stdin {
 			warn("failed to close input file: %s", err)
 		}
 	}()
 
-	scanAndStatInput(input, createStatOutputFile(inputPath), yamlPath, debug)
+	prefix := statOutputPrefix(inputPath)
+
+	scanAndStatInput(prefix, decompressedInput(input), createStatOutputFile(prefix), yamlPath, debug)
+}
+
+// writeStatChecksums writes checksum files for our output files named after
+// the given prefix, using the given algorithm. Dies on error.
+func writeStatChecksums(prefix, algorithm string) {
+	paths := []string{
+		prefix + statOutputFileSuffix,
+		prefix + statUserGroupSummaryOutputFileSuffix,
+		prefix + statGroupSummaryOutputFileSuffix,
+	}
+
+	if statXattr != "" {
+		paths = append(paths, prefix+statXattrSummaryOutputFileSuffix)
+	}
+
+	if err := writeChecksumFiles(algorithm, paths...); err != nil {
+		die("failed to write checksums: %s", err)
+	}
+}
+
+// openStatInput opens the given input file, or returns STDIN if inputPath is
+// "-". Dies on error.
+func openStatInput(inputPath string) *os.File {
+	if inputPath == statStdinInput {
+		return os.Stdin
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		die("failed to open input file: %s", err)
+	}
+
+	return input
 }
 
-// createStatOutputFile creates a file named input.stats.
-func createStatOutputFile(input string) *os.File {
-	return createOutputFileWithSuffix(input, statOutputFileSuffix)
+// statOutputPrefix returns the given input path without any compressed input
+// suffix, for naming our output files after. For STDIN input, returns
+// --output instead.
+func statOutputPrefix(inputPath string) string {
+	if inputPath == statStdinInput {
+		return statOutput
+	}
+
+	return strings.TrimSuffix(inputPath, compressedInputSuffix)
+}
+
+// decompressedInput returns a reader that decompresses the given input if its
+// name has a compressed input suffix, otherwise returns the input as-is. Dies
+// if the input isn't valid gzip data.
+func decompressedInput(input *os.File) io.Reader {
+	if !strings.HasSuffix(input.Name(), compressedInputSuffix) {
+		return input
+	}
+
+	zr, err := gzip.NewReader(input)
+	if err != nil {
+		die("failed to decompress input file: %s", err)
+	}
+
+	return zr
+}
+
+// createStatOutputFile creates a file named prefix.stats.
+func createStatOutputFile(prefix string) *os.File {
+	return createOutputFileWithSuffix(prefix, statOutputFileSuffix)
 }
 
 // createOutputFileWithSuffix creates an output file named after prefixPath
@@ -197,13 +324,14 @@ func createOutputFileWithSuffix(prefixPath, suffix string) *os.File {
 }
 
 // scanAndStatInput scans through the input, stats each path, and outputs the
-// results to the output.
+// results to the output. Summary output files are named after the given
+// prefix.
 //
 // If yamlPath is not empty, also does chmod and chown operations on certain
 // paths.
 //
 // If debug is true, outputs timings for Lstat calls and other operations.
-func scanAndStatInput(input, output *os.File, yamlPath string, debug bool) {
+func scanAndStatInput(prefix string, input io.Reader, output *os.File, yamlPath string, debug bool) {
 	var frequency time.Duration
 	if debug {
 		frequency = reportFrequency
@@ -211,12 +339,13 @@ func scanAndStatInput(input, output *os.File, yamlPath string, debug bool) {
 
 	statter := stat.WithTimeout(lstatTimeout, lstatAttempts, appLogger)
 	p := stat.NewPaths(statter, appLogger, frequency)
+	configurePaths(p)
 
 	if err := p.AddOperation("file", stat.FileOperation(output)); err != nil {
 		die("%s", err)
 	}
 
-	postScan, err := addSummaryOperations(input.Name(), p)
+	postScan, err := addSummaryOperations(prefix, p)
 	if err != nil {
 		die("%s", err)
 	}
@@ -234,25 +363,55 @@ func scanAndStatInput(input, output *os.File, yamlPath string, debug bool) {
 	}
 }
 
-// addSummaryOperations adds summary operations to p. Returns a function that
-// should be called after p.Scan.
+// configurePaths configures the given Paths according to --typed_input,
+// --skip_symlinks and --files_only.
+func configurePaths(p *stat.Paths) {
+	if statTypedInput {
+		p.TypedInput()
+	}
+
+	switch {
+	case statFilesOnly:
+		p.SkipTypes(stat.FileTypeLink, stat.FileTypeDir, stat.FileTypeSocket, stat.FileTypeBlock,
+			stat.FileTypeChar, stat.FileTypeFIFO, stat.FileTypeUnknown)
+	case statSkipSymlinks:
+		p.SkipTypes(stat.FileTypeLink)
+	}
+}
+
+// addSummaryOperations adds summary operations to p, including the xattr
+// summary if --xattr was supplied, and a check on atimes. Returns a function
+// that should be called after p.Scan.
 func addSummaryOperations(input string, p *stat.Paths) (func() error, error) {
-	outputUserGroupSummaryData, err := addUserGroupSummaryOperation(input, p)
-	if err != nil {
-		return nil, err
+	adders := []func(string, *stat.Paths) (func() error, error){
+		addUserGroupSummaryOperation,
+		addGroupSummaryOperation,
+		addAtimeCheckOperation,
 	}
 
-	outputGroupSummaryData, err := addGroupSummaryOperation(input, p)
-	if err != nil {
-		return nil, err
+	if statXattr != "" {
+		adders = append(adders, addXattrSummaryOperation)
+	}
+
+	outputFuncs := make([]func() error, len(adders))
+
+	for i, add := range adders {
+		outputFunc, err := add(input, p)
+		if err != nil {
+			return nil, err
+		}
+
+		outputFuncs[i] = outputFunc
 	}
 
 	return func() error {
-		if err = outputUserGroupSummaryData(); err != nil {
-			return err
+		for _, outputFunc := range outputFuncs {
+			if err := outputFunc(); err != nil {
+				return err
+			}
 		}
 
-		return outputGroupSummaryData()
+		return nil
 	}, nil
 }
 
@@ -294,6 +453,16 @@ func addGroupSummaryOperation(input string, p *stat.Paths) (func() error, error)
 	return addSummaryOperator(input, statGroupSummaryOutputFileSuffix, "group", p, g)
 }
 
+// addXattrSummaryOperation adds an operation to Paths that collects [xattr
+// value, count, size] summary information for our --xattr. It returns a
+// function that you should call after calling p.Scan(), which outputs the
+// summary data to file.
+func addXattrSummaryOperation(input string, p *stat.Paths) (func() error, error) {
+	x := summary.NewByXattr(statXattr)
+
+	return addSummaryOperator(input, statXattrSummaryOutputFileSuffix, "xattr", p, x)
+}
+
 // addChOperation adds the chmod&chown operation to the Paths if the yaml file
 // has valid contents. No-op if yamlPath is blank.
 func addChOperation(yamlPath string, p *stat.Paths) error {
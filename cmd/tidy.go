@@ -26,22 +26,30 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
-	shutil "github.com/termie/go-shutil"
+	"golang.org/x/sys/unix"
 )
 
 // modeRW are the read-write permission bits for user, group and other.
 const modeRW = 0666
 
+var errFinalOutputExists = errors.New("final output file already exists")
+
 // options for this cmd.
 var tidyDir string
 var tidyDate string
+var tidyDeleteJitter time.Duration
+var tidyDeleteRate int
 
 // tidyCmd represents the tidy command.
 var tidyCmd = &cobra.Command{
@@ -64,15 +72,28 @@ It probably won't do the right thing if not.
 Final output files are named to include the given --date as follows:
 [date]_[interest basename].[interest unique].[multi unique].[suffix]
 
-Where [suffix] is one of 'stats.gz', 'byusergroup.gz', 'bygroup' or 'logs.gz'.
+Where [suffix] is one of 'stats.gz', 'byusergroup.gz', 'bygroup' or 'logs.gz',
+or 'bygroup.csv' or 'bygroup.json' if 'wrstat combine' was run with --format,
+or 'byxattr' if 'wrstat stat' was run with --xattr.
 
 The output files will be given the same user:group ownership and
 user,group,other read & write permissions as the --final_output directory.
 
 Once all output files have been moved, the "multi unique" directory is deleted.
+Deleting a large working directory puts load on the filesystem's metadata
+server, so if many tidies would happen at the same time, you can spread them
+out: --delete_jitter waits a random duration up to the given one (eg. 30m)
+before deleting, and --delete_rate deletes no more than the given number of
+files and directories per second. Everything is still eventually deleted.
+
+Existing files in the --final_output directory are never overwritten; if a
+final output file name is already taken, this fails with an error instead.
+Files are moved such that a partially written final output file can't exist,
+even if moving between filesystems.
 
 It is safe to call this multiple times if it was, for example, killed half way
-through; it won't clobber final outputs already moved.`,
+through; it won't clobber final outputs already moved, and output files that
+were moved but not yet removed from the working directory are just removed.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if tidyDir == "" {
 			die("--final_output is required")
@@ -114,31 +135,37 @@ func init() {
 	// flags specific to this sub-command
 	tidyCmd.Flags().StringVarP(&tidyDir, "final_output", "f", "", "final output directory")
 	tidyCmd.Flags().StringVarP(&tidyDate, "date", "d", "", "datestamp of when 'wrstat multi' was called")
+	addDeleteFlags(tidyCmd, "")
 }
 
 // moveAndDelete does the main work of this cmd.
 func moveAndDelete(sourceDir, destDir string, destDirInfo fs.FileInfo, date string) error {
-	if err := findAndMoveOutputs(sourceDir, destDir, destDirInfo, date,
-		combineStatsOutputFileBasename, "stats.gz"); err != nil {
-		return err
-	}
-
-	if err := findAndMoveOutputs(sourceDir, destDir, destDirInfo, date,
-		combineUserGroupOutputFileBasename, "byusergroup.gz"); err != nil {
-		return err
+	for _, output := range [][2]string{
+		{combineStatsOutputFileBasename, "stats.gz"},
+		{combineUserGroupOutputFileBasename, "byusergroup.gz"},
+		{combineGroupOutputFileBasename, "bygroup"},
+		{combineXattrOutputFileBasename, "byxattr"},
+		{combineLogOutputFileBasename, "logs.gz"},
+	} {
+		if err := findAndMoveOutputs(sourceDir, destDir, destDirInfo, date, output[0], output[1]); err != nil {
+			return err
+		}
 	}
 
-	if err := findAndMoveOutputs(sourceDir, destDir, destDirInfo, date,
-		combineGroupOutputFileBasename, "bygroup"); err != nil {
+	if err := findAndMoveExportOutputs(sourceDir, destDir, destDirInfo, date); err != nil {
 		return err
 	}
 
-	if err := findAndMoveOutputs(sourceDir, destDir, destDirInfo, date,
-		combineLogOutputFileBasename, "logs.gz"); err != nil {
-		return err
-	}
+	return removeWorkingDir(sourceDir, tidyDeleteJitter, tidyDeleteRate)
+}
 
-	return os.RemoveAll(sourceDir)
+// addDeleteFlags adds the --delete_jitter and --delete_rate flags to the given
+// command, with the given note appended to their usage.
+func addDeleteFlags(cmd *cobra.Command, note string) {
+	cmd.Flags().DurationVar(&tidyDeleteJitter, "delete_jitter", 0,
+		"wait a random duration up to this before deleting the working directory"+note)
+	cmd.Flags().IntVar(&tidyDeleteRate, "delete_rate", 0,
+		"maximum files and directories to delete per second (0 for no limit)"+note)
 }
 
 // findAndMoveOutputs finds output files in the given sourceDir with given
@@ -159,6 +186,20 @@ func findAndMoveOutputs(sourceDir, destDir string, destDirInfo fs.FileInfo,
 	return nil
 }
 
+// findAndMoveExportOutputs calls findAndMoveOutputs() for each of the
+// additional formats 'wrstat combine' can export bygroup data in. Formats that
+// weren't exported are ignored.
+func findAndMoveExportOutputs(sourceDir, destDir string, destDirInfo fs.FileInfo, date string) error {
+	for format := range groupSummaryExporters {
+		if err := findAndMoveOutputs(sourceDir, destDir, destDirInfo, date,
+			combineGroupOutputFileBasename+"."+format, "bygroup."+format); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // moveOutputs calls moveOutput() on each outputPaths source file.
 func moveOutputs(outputPaths []string, destDir string, destDirInfo fs.FileInfo, date, suffix string) error {
 	for _, path := range outputPaths {
@@ -173,7 +214,7 @@ func moveOutputs(outputPaths []string, destDir string, destDirInfo fs.FileInfo,
 
 // moveOutput moves an output file to the finalDir and changes its name to
 // the correct format, then adjusts ownership and permissions to match the
-// destDir.
+// destDir. Returns an error if a file with the final name already exists.
 func moveOutput(source string, destDir string, destDirInfo fs.FileInfo, date, suffix string) error {
 	interestUniqueDir := filepath.Dir(source)
 	interestBaseDir := filepath.Dir(interestUniqueDir)
@@ -185,14 +226,186 @@ func moveOutput(source string, destDir string, destDirInfo fs.FileInfo, date, su
 		filepath.Base(multiUniqueDir),
 		suffix))
 
-	err := os.Rename(source, dest)
+	if err := moveWithoutClobbering(source, dest); err != nil {
+		return err
+	}
+
+	return matchPerms(dest, destDirInfo)
+}
+
+// moveWithoutClobbering moves source to dest, failing if a different file
+// already exists at dest. Moves to a different filesystem copy to a temporary
+// file first, so that a partially written dest never exists.
+//
+// If dest is already source (or a copy of it), because a previous call was
+// killed before it could remove source, source is just removed.
+func moveWithoutClobbering(source, dest string) error {
+	err := renameWithoutClobbering(source, dest)
+	if errors.Is(err, syscall.EXDEV) {
+		if err = copyWithoutClobbering(source, dest); err == nil {
+			return os.Remove(source)
+		}
+	}
+
+	if errors.Is(err, errFinalOutputExists) && alreadyMoved(source, dest) {
+		return os.Remove(source)
+	}
+
+	return err
+}
+
+// alreadyMoved returns true if dest is the same file as source, or has the same
+// contents.
+func alreadyMoved(source, dest string) bool {
+	sourceInfo, err := os.Stat(source)
 	if err != nil {
-		if err = shutil.CopyFile(source, dest, false); err != nil {
-			return err
+		return false
+	}
+
+	destInfo, err := os.Stat(dest)
+	if err != nil {
+		return false
+	}
+
+	if os.SameFile(sourceInfo, destInfo) {
+		return true
+	}
+
+	return sourceInfo.Size() == destInfo.Size() && sameContents(source, dest)
+}
+
+// sameContents returns true if the files at the given paths have identical
+// contents.
+func sameContents(a, b string) bool {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false
+	}
+
+	defer fa.Close()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false
+	}
+
+	defer fb.Close()
+
+	ra, rb := bufio.NewReader(fa), bufio.NewReader(fb)
+
+	for {
+		ba, erra := ra.ReadByte()
+		bb, errb := rb.ReadByte()
+
+		if erra != nil || errb != nil {
+			return errors.Is(erra, io.EOF) && errors.Is(errb, io.EOF)
+		}
+
+		if ba != bb {
+			return false
 		}
 	}
+}
 
-	return matchPerms(dest, destDirInfo)
+// renameWithoutClobbering renames source to dest, returning an
+// errFinalOutputExists if dest already exists. This uses renameat2() with
+// RENAME_NOREPLACE, falling back to renameOverPlaceholder() where that isn't
+// supported. Neither needs hard link support.
+func renameWithoutClobbering(source, dest string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, source, unix.AT_FDCWD, dest, unix.RENAME_NOREPLACE)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTSUP) {
+		err = renameOverPlaceholder(source, dest)
+	}
+
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %s", errFinalOutputExists, dest)
+	}
+
+	return err
+}
+
+// renameOverPlaceholder reserves dest by exclusively creating an empty file
+// there, then renames source over it. Returns an error satisfying
+// errors.Is(err, fs.ErrExist) if dest already exists. The placeholder is
+// removed if the rename fails.
+func renameOverPlaceholder(source, dest string) error {
+	placeholder, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, modeRW)
+	if err != nil {
+		return err
+	}
+
+	if err = placeholder.Close(); err == nil {
+		err = os.Rename(source, dest)
+	}
+
+	if err != nil {
+		if errr := os.Remove(dest); errr != nil {
+			warn("failed to remove placeholder file: %s", errr)
+		}
+	}
+
+	return err
+}
+
+// copyWithoutClobbering copies source to a temporary file in dest's directory,
+// syncs it, and then renames it to dest without clobbering.
+func copyWithoutClobbering(source, dest string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".tidy.*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if errr := os.Remove(tmp.Name()); errr != nil && !errors.Is(errr, fs.ErrNotExist) {
+			warn("failed to remove temporary file: %s", errr)
+		}
+	}()
+
+	err = copyAndSync(source, tmp)
+	if errc := tmp.Close(); err == nil {
+		err = errc
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return renameWithoutClobbering(tmp.Name(), dest)
+}
+
+// copyAndSync copies the contents and permissions of the source file to dest,
+// then syncs dest to disk.
+func copyAndSync(source string, dest *os.File) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if errc := in.Close(); errc != nil {
+			warn("failed to close file: %s", errc)
+		}
+	}()
+
+	if err = copyPerms(in, dest); err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dest, in); err != nil {
+		return err
+	}
+
+	return dest.Sync()
+}
+
+// copyPerms gives dest the same permissions as source.
+func copyPerms(source, dest *os.File) error {
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	return dest.Chmod(info.Mode().Perm())
 }
 
 // matchPerms ensures that the given file has the same ownership and read-write
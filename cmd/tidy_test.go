@@ -0,0 +1,112 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMoveWithoutClobbering(t *testing.T) {
+	Convey("Given a source file and a destination directory", t, func() {
+		dir := t.TempDir()
+		source := filepath.Join(dir, "source")
+		dest := filepath.Join(dir, "dest")
+
+		So(os.WriteFile(source, []byte("data"), userOnlyPerm), ShouldBeNil)
+
+		Convey("The source can be moved to a new destination", func() {
+			So(moveWithoutClobbering(source, dest), ShouldBeNil)
+			So(source, shouldNotExist)
+			So(dest, shouldHaveContents, "data")
+		})
+
+		Convey("A different existing destination isn't clobbered", func() {
+			So(os.WriteFile(dest, []byte("other"), userOnlyPerm), ShouldBeNil)
+
+			err := moveWithoutClobbering(source, dest)
+			So(errors.Is(err, errFinalOutputExists), ShouldBeTrue)
+			So(source, shouldHaveContents, "data")
+			So(dest, shouldHaveContents, "other")
+		})
+
+		Convey("A destination that is already the source just has the source removed", func() {
+			So(os.Link(source, dest), ShouldBeNil)
+
+			So(moveWithoutClobbering(source, dest), ShouldBeNil)
+			So(source, shouldNotExist)
+			So(dest, shouldHaveContents, "data")
+		})
+
+		Convey("A destination that is an identical copy just has the source removed", func() {
+			So(os.WriteFile(dest, []byte("data"), userOnlyPerm), ShouldBeNil)
+
+			So(moveWithoutClobbering(source, dest), ShouldBeNil)
+			So(source, shouldNotExist)
+			So(dest, shouldHaveContents, "data")
+		})
+
+		Convey("Renaming over a placeholder works without clobbering", func() {
+			So(renameOverPlaceholder(source, dest), ShouldBeNil)
+			So(source, shouldNotExist)
+			So(dest, shouldHaveContents, "data")
+
+			So(os.WriteFile(source, []byte("new"), userOnlyPerm), ShouldBeNil)
+
+			err := renameOverPlaceholder(source, dest)
+			So(errors.Is(err, os.ErrExist), ShouldBeTrue)
+			So(dest, shouldHaveContents, "data")
+		})
+
+		Convey("Copying works without clobbering, leaving no temporary files", func() {
+			So(copyWithoutClobbering(source, dest), ShouldBeNil)
+			So(source, shouldHaveContents, "data")
+			So(dest, shouldHaveContents, "data")
+
+			err := copyWithoutClobbering(source, dest)
+			So(errors.Is(err, errFinalOutputExists), ShouldBeTrue)
+
+			entries, err := os.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+		})
+	})
+}
+
+// shouldHaveContents is a goconvey assertion that the file at the given path
+// contains the expected string.
+func shouldHaveContents(actual interface{}, expected ...interface{}) string {
+	content, err := os.ReadFile(actual.(string)) //nolint:forcetypeassert
+	if err != nil {
+		return err.Error()
+	}
+
+	return ShouldEqual(string(content), expected...)
+}
@@ -0,0 +1,87 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/klauspost/pgzip"
+)
+
+// lineCounter is an io.Writer that counts the lines written to it.
+type lineCounter struct {
+	lines int
+}
+
+// Write counts the newlines in p.
+func (l *lineCounter) Write(p []byte) (int, error) {
+	l.lines += bytes.Count(p, []byte{'\n'})
+
+	return len(p), nil
+}
+
+// verifyCompressedLines checks that the given compressed file can be completely
+// decompressed and contains the expected number of lines. Dies if not.
+func verifyCompressedLines(path string, expected int) {
+	found, err := countCompressedLines(path)
+	if err != nil {
+		die("failed to verify [%s]: %s", path, err)
+	}
+
+	if found != expected {
+		die("failed to verify [%s]: expected %d lines, found %d", path, expected, found)
+	}
+}
+
+// countCompressedLines returns the number of lines in the given compressed
+// file.
+func countCompressedLines(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if errc := file.Close(); errc != nil {
+			warn("failed to close file: %s", errc)
+		}
+	}()
+
+	zr, err := pgzip.NewReader(file)
+	if err != nil {
+		return 0, err
+	}
+
+	counter := &lineCounter{}
+
+	if _, err = io.Copy(counter, zr); err != nil {
+		return 0, err
+	}
+
+	return counter.lines, zr.Close()
+}
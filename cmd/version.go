@@ -27,24 +27,41 @@ package cmd
 
 import (
 	"fmt"
+	"runtime"
 
 	"github.com/spf13/cobra"
 )
 
-// Version gets set during build:
+// Version, Commit and BuildDate get set during build (see the Makefile):
 // go build -ldflags "-X github.com/wtsi-ssg/wrstat/cmd.Version=`git describe --tags --always --long --dirty`" .
 var Version string
+var Commit string
+var BuildDate string
 
 // versionCmd represents the version command.
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print wrstat version",
-	Long:  `Print wrstat version.`,
+	Long: `Print wrstat version.
+
+As well as the version, this prints the git commit and date wrstat was built
+from and with, and the version of Go it was built with. This is also available
+as 'wrstat --version'.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(Version)
+		fmt.Print(versionDetails())
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(versionCmd)
+
+	RootCmd.Version = versionDetails()
+	RootCmd.SetVersionTemplate("{{.Version}}")
+}
+
+// versionDetails returns our Version on the first line, followed by lines
+// for our build metadata.
+func versionDetails() string {
+	return fmt.Sprintf("%s\ncommit: %s\nbuild date: %s\ngo: %s\n",
+		Version, Commit, BuildDate, runtime.Version())
 }
@@ -26,12 +26,20 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/VertebrateResequencing/wr/jobqueue"
+	jqs "github.com/VertebrateResequencing/wr/jobqueue/scheduler"
 	"github.com/spf13/cobra"
 	"github.com/wtsi-ssg/wrstat/scheduler"
 	"github.com/wtsi-ssg/wrstat/walk"
@@ -42,6 +50,13 @@ const (
 	walkLogOutputBasename = "walk.log"
 	statTime              = 12 * time.Hour
 	statRAM               = 750
+
+	defaultProgressInterval = 30 * time.Second
+
+	// balanceBatchDivisor is how many batches of paths each output file will
+	// receive with --balance; it bounds line count differences between output
+	// files to 1/balanceBatchDivisor of --inodes_per_stat.
+	balanceBatchDivisor = 100
 )
 
 // options for this cmd.
@@ -50,6 +65,24 @@ var depGroup string
 var walkInodesPerJob int
 var walkID string
 var walkCh string
+var walkCapEntries int
+var walkBalance bool
+var walkExcludes []string
+var walkExcludeFile string
+var walkResume bool
+var walkCompress bool
+var walkOneFileSystem bool
+var walkProgressInterval time.Duration
+var walkWorkers int
+var walkEmitTypes bool
+var walkFollowSymlinks bool
+var walkMaxDepth int
+var walkDryRun bool
+var statReqRAM int
+var statReqTime time.Duration
+var statReqCores float64
+var statReqDisk int
+var statRetries int
 
 // walkCmd represents the walk command.
 var walkCmd = &cobra.Command{
@@ -70,15 +103,102 @@ For each entry recursively within the directory of interest, their paths are
 written to output files in the given output directory. The number of files is
 such that they will each contain about --inodes_per_stat entries.
 
+The top level subdirectories of the directory of interest are walked
+concurrently. By default they are all walked at once, but you can limit how
+many are walked at the same time with --walk_workers.
+
+To avoid running out of file descriptors, you can supply --max_open_files (eg.
+a little below your 'ulimit -n'). The output files plus the directories being
+read are then kept within that limit: if there would be too many output files,
+the walk doesn't start. Otherwise, the number of concurrent subdirectory walks
+is reduced as necessary, assuming each has up to 32 nested directories open at
+once. The default of 0 means no limit. Either way, if the walk runs out of file
+descriptors, it fails with advice about the limit, instead of skipping paths.
+
+To avoid pathological directories with enormous numbers of immediate children
+bloating the output, you can supply --cap_entries_per_dir. Once a directory has
+had that many entries output, the rest of its entries are skipped (and not
+descended in to), and the directory and its observed entry count are noted in
+the walk.log file.
+
+By default, each path found is written to the next output file in turn. With
+--balance, paths found together (eg. siblings in a directory) are instead
+written in batches, with each batch going to the output file that currently has
+the fewest paths. This keeps related paths together for the stat jobs, while
+keeping the number of paths in each output file within 1% of each other.
+
+You can skip entries (and everything nested within them) by supplying glob
+patterns to --exclude, which can be given multiple times, or one per line in a
+file supplied to --exclude_file. Patterns containing a / are matched against
+full paths, while others are matched against basenames, so eg. --exclude
+.snapshot will skip all .snapshot directories.
+
 For each output file, a 'wrstat stat' job is then added to wr's queue with the
-given dependency group. For the meaning of the --ch option which is passed
-through to stat, see 'wrstat stat -h'.
+given dependency group. For the meaning of the --ch, --xattr, --skip_symlinks
+and --files_only options which are passed through to stat, see
+'wrstat stat -h'. The resources the stat jobs are expected to need can be
+adjusted with the --req_* options, where --req_ram is in MB, --req_time is a
+duration like '12h' and --req_disk is in GB. --req_time is for an output file
+with --inodes_per_stat paths, and is increased in proportion for any output
+files that end up with more. Failed stat jobs will be retried up to --retries
+times (0-255).
 
 (When jobs are added to wr's queue to get the work done, they are given a
 --rep_grp of wrstat-stat-[id], so you can use
 'wr status -i wrstat-stat -z -o s' to get information on how long everything or
 particular subsets of jobs took.)
 
+Paths that can't be read due to permission problems, or that vanish during the
+walk, are noted in the walk.log file and skipped (along with everything nested
+within them), and a count of skipped paths is logged once the walk completes.
+
+With --resume, progress is recorded in a walk.progress file in the output
+directory as each top level subdirectory of the directory of interest is
+completely walked. If such a walk is interrupted, you can rerun the same command
+and the existing output files will be appended to instead of being replaced,
+with completed subdirectories not being walked again. (Without --resume, no
+progress is recorded, since that involves syncing every output file to disk
+each time a top level subdirectory is completed.)
+
+With --one_file_system, the walk stays on the filesystem of the directory of
+interest, like 'find -xdev': directories that are mount points of other
+filesystems are output, but not descended in to.
+
+By default, symbolic links are output but not followed. With --follow_symlinks,
+symlinks to directories are descended in to, with the paths within them being
+output under the symlink's path rather than its target's. Each directory is only
+descended in to the first time it is found, however it is reached, so circular
+links don't cause an infinite walk and data isn't output more than once; which
+of the paths to a directory its contents are output under is arbitrary.
+
+To guard against pathologically deep directory trees, --max_depth limits how
+many levels below the directory of interest are output, like 'find -maxdepth':
+directories at the limit are output but not descended in to, and 0 means only
+the directory of interest itself is output. The number of directories that
+weren't descended in to is logged. The default of -1 means no limit.
+
+While walking, the number of paths output so far and the current rate of output
+are logged every --progress_interval (0 to disable), followed by the total and
+elapsed time once the walk completes.
+
+To save space, you can supply --compress to have the output files gzip
+compressed, in which case they are named walk.N.gz; 'wrstat stat' will
+decompress them as it reads them. Compressed walks can't be resumed.
+
+With --emit_types, each line of the output files is a path followed by a tab and
+the type of the path (using the same letters as the 'wrstat stat' Filetype
+column), as known from reading its directory. The stat jobs are then told to
+expect this with --typed_input. This is implied by --skip_symlinks and
+--files_only, so that stat can skip the unwanted paths without an lstat.
+
+With --dry_run, wr manager doesn't need to be running: the walk still happens
+and the output files are still created, but the details of the stat jobs that
+would have been added to wr's queue are printed instead.
+
+Once the walk completes, a walk.manifest.json file is written to the output
+directory. It is a JSON array with an object per output file, giving its 'path',
+number of 'lines' (paths) and size in 'bytes'.
+
 NB: when this exits, that does not mean all stats have necessarily been
 retrieved. You should wait until all jobs in the given dependency group have
 completed (eg. by adding your own job that depends on that group, such as a
@@ -86,7 +206,7 @@ completed (eg. by adding your own job that depends on that group, such as a
 	Run: func(cmd *cobra.Command, args []string) {
 		desiredDir := checkArgs(outputDir, depGroup, args)
 
-		s, d := newScheduler("")
+		s, d := newScheduler("", walkDryRun)
 		defer d()
 
 		if walkID == "" {
@@ -114,9 +234,61 @@ func init() {
 		"dependency_group", "d", "",
 		"dependency group that stat jobs added to wr will belong to")
 	walkCmd.Flags().StringVar(&walkCh, "ch", "", "passed through to 'wrstat stat'")
+	walkCmd.Flags().StringVar(&statXattr, "xattr", "", "passed through to 'wrstat stat'")
+	walkCmd.Flags().BoolVar(&statSkipSymlinks, "skip_symlinks", false, "passed through to 'wrstat stat'")
+	walkCmd.Flags().BoolVar(&statFilesOnly, "files_only", false, "passed through to 'wrstat stat'")
+	walkCmd.Flags().BoolVar(&walkDryRun, "dry_run", false,
+		"print the stat jobs that would be added to wr's queue instead of adding them")
+
+	addWalkerFlags()
+	addStatReqFlags()
+	addManagerFlags(walkCmd)
 }
 
-// checkArgs checks we have required args and returns desired dir.
+// addWalkerFlags adds the flags that configure the walk.Walker to our walk
+// command.
+func addWalkerFlags() {
+	walkCmd.Flags().IntVar(&walkCapEntries, "cap_entries_per_dir", 0,
+		"only output the first N entries of any directory (0 means no cap)")
+	walkCmd.Flags().BoolVar(&walkBalance, "balance", false,
+		"write batches of paths to the least full output file")
+	walkCmd.Flags().StringArrayVar(&walkExcludes, "exclude", nil,
+		"glob pattern of paths to skip (can be repeated)")
+	walkCmd.Flags().StringVar(&walkExcludeFile, "exclude_file", "",
+		"file containing --exclude patterns, one per line")
+	walkCmd.Flags().BoolVar(&walkResume, "resume", false,
+		"record progress, continuing any interrupted walk that output to the same --output_directory")
+	walkCmd.Flags().BoolVar(&walkCompress, "compress", false, "gzip compress the output files")
+	walkCmd.Flags().BoolVar(&walkOneFileSystem, "one_file_system", false,
+		"don't descend in to directories on other filesystems")
+	walkCmd.Flags().DurationVar(&walkProgressInterval, "progress_interval", defaultProgressInterval,
+		"how often to log walk progress")
+	walkCmd.Flags().BoolVar(&walkFollowSymlinks, "follow_symlinks", false,
+		"descend in to symlinks to directories")
+	walkCmd.Flags().IntVar(&walkMaxDepth, "max_depth", -1,
+		"maximum number of levels below the directory of interest to walk (-1 for no limit)")
+	walkCmd.Flags().BoolVar(&walkEmitTypes, "emit_types", false,
+		"output the type of each path alongside it")
+	walkCmd.Flags().IntVar(&walkMaxOpenFiles, "max_open_files", 0,
+		"maximum number of files to have open while walking (0 for no limit)")
+	walkCmd.Flags().IntVar(&walkWorkers, "walk_workers", 0,
+		"max top level subdirectories to walk concurrently (0 means all, within --max_open_files)")
+}
+
+// addStatReqFlags adds the --req_* and --retries flags for setting the
+// requirements of stat jobs to our walk command.
+func addStatReqFlags() {
+	defaultReqs := scheduler.DefaultRequirements()
+	walkCmd.Flags().IntVar(&statReqRAM, "req_ram", statRAM, "MB of memory each stat job needs")
+	walkCmd.Flags().DurationVar(&statReqTime, "req_time", statTime, "time each stat job needs")
+	walkCmd.Flags().Float64Var(&statReqCores, "req_cores", defaultReqs.Cores, "cores each stat job needs")
+	walkCmd.Flags().IntVar(&statReqDisk, "req_disk", defaultReqs.Disk, "GB of local disk each stat job needs")
+	walkCmd.Flags().IntVar(&statRetries, "retries", int(scheduler.DefaultRetries()),
+		"number of times to retry failed stat jobs")
+}
+
+// checkArgs checks we have required args and a valid --retries, and returns
+// desired dir.
 func checkArgs(out, dep string, args []string) string {
 	if out == "" {
 		die("--output_directory is required")
@@ -130,6 +302,10 @@ func checkArgs(out, dep string, args []string) string {
 		die("exactly 1 directory of interest must be supplied")
 	}
 
+	if statRetries < 0 || statRetries > math.MaxUint8 {
+		die("--retries must be between 0 and %d", math.MaxUint8)
+	}
+
 	return args[0]
 }
 
@@ -144,26 +320,195 @@ func walkDirAndScheduleStats(desiredDir, outputDir string, inodes int, depGroup,
 	yamlPath string, s *scheduler.Scheduler) {
 	n := calculateSplitBasedOnInodes(inodes, desiredDir)
 
-	walker, err := walk.New(outputDir, n)
+	walker := newWalker(outputDir, n, inodes)
+
+	counter := &walkErrorCounter{}
+
+	err := walker.Walk(desiredDir, counter.callback)
+	if err != nil {
+		die("failed to walk the filesystem: %s%s", err, openFilesHint(err))
+	}
+
+	counter.summarise()
+	summariseTruncation(walker)
+
+	if err = walker.Close(); err != nil {
+		die("failed to close walk output files: %s", err)
+	}
+
+	entries := writeWalkManifest(outputDir, walker)
+
+	scheduleStatJobs(entries, inodes, depGroup, repGroup, yamlPath, s)
+}
+
+// newWalker returns a walk.Walker that will output to n files in outputDir,
+// that should each end up with about inodes paths, configured according to our
+// command line options. Dies on error, including on invalid --exclude patterns
+// before any output files are created.
+func newWalker(outputDir string, n, inodes int) *walk.Walker {
+	create := walk.New
+	if walkResume {
+		create = walk.Resume
+	}
+
+	if err := walk.ValidateExcludes(excludePatterns()); err != nil {
+		die("invalid --exclude pattern: %s", err)
+	}
+
+	checkOutputFilesWithinLimit(n)
+
+	walker, err := create(outputDir, n)
 	if err != nil {
-		die("failed to create walk output files: %s", err)
+		die("failed to create walk output files: %s%s", err, openFilesHint(err))
+	}
+
+	configureWalker(walker, inodes)
+
+	return walker
+}
+
+// configureWalker applies our command line options to the given walker, which
+// should output files that each end up with about inodes paths. Dies on error.
+func configureWalker(walker *walk.Walker, inodes int) {
+	walker.CapEntriesPerDir(walkCapEntries)
+	walker.ReportProgress(appLogger, walkProgressInterval)
+	walker.Workers(walkWorkersWithinLimit(len(walker.OutputPaths())))
+	setWalkerModes(walker)
+
+	if err := walker.Exclude(excludePatterns()); err != nil {
+		die("invalid --exclude pattern: %s", err)
 	}
 
-	defer func() {
-		err = walker.Close()
-		if err != nil {
-			warn("failed to close walk output file: %s", err)
+	if walkBalance {
+		walker.Balance(balanceBatchSize(inodes))
+	}
+
+	if walkCompress {
+		if err := walker.Compress(); err != nil {
+			die("failed to compress walk output files: %s", err)
 		}
-	}()
+	}
+}
+
+// setWalkerModes turns on the given walker's modes according to
+// --one_file_system, --follow_symlinks, --max_depth and emittingTypes().
+func setWalkerModes(walker *walk.Walker) {
+	if walkOneFileSystem {
+		walker.OneFileSystem()
+	}
 
-	err = walker.Walk(desiredDir, func(path string, err error) {
-		warn("error processing %s: %s", path, err)
-	})
+	if walkFollowSymlinks {
+		walker.FollowSymlinks()
+	}
+
+	if emittingTypes() {
+		walker.EmitTypes()
+	}
+
+	walker.MaxDepth(walkMaxDepth)
+}
+
+// summariseTruncation logs how many directories the walker didn't descend in to
+// due to --max_depth, if any.
+func summariseTruncation(walker *walk.Walker) {
+	if n := walker.TruncatedDirs(); n > 0 {
+		appLogger.Warn("did not descend in to directories at --max_depth", "dirs", n, "max_depth", walkMaxDepth)
+	}
+}
+
+// emittingTypes returns true if the walk should output paths with their types,
+// which is the case for --emit_types, or if one of the stat options that can
+// take advantage of them was given.
+func emittingTypes() bool {
+	return walkEmitTypes || statSkipSymlinks || statFilesOnly
+}
+
+// excludePatterns returns the --exclude patterns along with those in the
+// --exclude_file, ignoring blank lines. Dies if the file can't be read.
+func excludePatterns() []string {
+	if walkExcludeFile == "" {
+		return walkExcludes
+	}
+
+	file, err := os.Open(walkExcludeFile)
 	if err != nil {
-		die("failed to walk the filesystem: %s", err)
+		die("could not open --exclude_file: %s", err)
+	}
+
+	patterns := readLines(file)
+
+	if err = file.Close(); err != nil {
+		warn("failed to close --exclude_file: %s", err)
+	}
+
+	return append(walkExcludes, patterns...)
+}
+
+// readLines returns the non-blank lines in the given reader, with surrounding
+// whitespace removed. Dies on error.
+func readLines(r *os.File) []string {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		die("failed to read %s: %s", r.Name(), err)
 	}
 
-	scheduleStatJobs(walker.OutputPaths(), depGroup, repGroup, yamlPath, s)
+	return lines
+}
+
+// balanceBatchSize returns the walk.Walker.Balance() batch size to use when
+// each output file should end up with about inodes paths.
+func balanceBatchSize(inodes int) int {
+	size := inodes / balanceBatchDivisor
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// walkErrorCounter provides a walk.ErrorCallback that logs problematic paths
+// and counts those that were skipped because we didn't have permission to read
+// them, or because they vanished during the walk.
+type walkErrorCounter struct {
+	denied   int64
+	vanished int64
+}
+
+// callback is a walk.ErrorCallback. It is thread-safe. Since running out of
+// file descriptors would make the walk silently incomplete, we die if that
+// happens.
+func (c *walkErrorCounter) callback(path string, err error) {
+	if hint := openFilesHint(err); hint != "" {
+		die("error processing %s: %s%s", path, err, hint)
+	}
+
+	appLogger.Warn("error processing path", "path", path, "err", err)
+
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		atomic.AddInt64(&c.denied, 1)
+	case errors.Is(err, fs.ErrNotExist):
+		atomic.AddInt64(&c.vanished, 1)
+	}
+}
+
+// summarise logs how many paths were skipped, if any.
+func (c *walkErrorCounter) summarise() {
+	denied, vanished := atomic.LoadInt64(&c.denied), atomic.LoadInt64(&c.vanished)
+	if denied == 0 && vanished == 0 {
+		return
+	}
+
+	appLogger.Warn("skipped paths during the walk", "denied", denied, "vanished", vanished)
 }
 
 // calculateSplitBasedOnInodes sees how many used inodes are on the given path
@@ -185,24 +530,72 @@ func calculateSplitBasedOnInodes(n int, mount string) int {
 	return jobs
 }
 
-// scheduleStatJobs adds a 'wrstat stat' job to wr's queue for each out path.
-// The jobs are added with the given dep and rep groups, and the given yaml for
-// the --ch arg if not blank.
-func scheduleStatJobs(outPaths []string, depGroup string, repGrp, yamlPath string, s *scheduler.Scheduler) {
-	jobs := make([]*jobqueue.Job, len(outPaths))
+// scheduleStatJobs adds a 'wrstat stat' job to wr's queue for each walk output
+// file. The jobs are added with the given dep and rep groups, and the given yaml
+// for the --ch arg if not blank. The time requirement of jobs for files with
+// more than the given inodes paths is increased in proportion.
+func scheduleStatJobs(entries []*walkManifestEntry, inodes int, depGroup string, repGrp, yamlPath string,
+	s *scheduler.Scheduler) {
+	jobs := make([]*jobqueue.Job, len(entries))
+	cmd := statCommand(s.Executable(), yamlPath)
+
+	for i, entry := range entries {
+		jobs[i] = s.NewJob(cmd+entry.Path, repGrp, "wrstat-stat", depGroup, "", statReqsForLines(entry.Lines, inodes))
+		jobs[i].Retries = uint8(statRetries)
+	}
+
+	addJobsToQueue(s, jobs)
+}
 
-	cmd := fmt.Sprintf("%s stat ", s.Executable())
+// statCommand returns the start of a 'wrstat stat' command line using the given
+// exe, passing through the given yamlPath as --ch if not blank, --xattr,
+// --skip_symlinks and --files_only if supplied, and --typed_input if we're
+// emittingTypes().
+func statCommand(exe, yamlPath string) string {
+	cmd := fmt.Sprintf("%s stat ", exe)
 	if yamlPath != "" {
 		cmd += fmt.Sprintf("--ch %s ", yamlPath)
 	}
 
-	req := scheduler.DefaultRequirements()
-	req.Time = statTime
-	req.RAM = statRAM
+	if statXattr != "" {
+		cmd += fmt.Sprintf("--xattr %s ", statXattr)
+	}
 
-	for i, path := range outPaths {
-		jobs[i] = s.NewJob(cmd+path, repGrp, "wrstat-stat", depGroup, "", req)
+	if emittingTypes() {
+		cmd += "--typed_input "
 	}
 
-	addJobsToQueue(s, jobs)
+	if statSkipSymlinks {
+		cmd += "--skip_symlinks "
+	}
+
+	if statFilesOnly {
+		cmd += "--files_only "
+	}
+
+	return cmd
+}
+
+// statReqsForLines returns statReqs(), but with the time increased in
+// proportion for files with more than inodes lines.
+func statReqsForLines(lines, inodes int) *jqs.Requirements {
+	req := statReqs()
+
+	if inodes > 0 && lines > inodes {
+		req.Time = time.Duration(float64(req.Time) * float64(lines) / float64(inodes))
+	}
+
+	return req
+}
+
+// statReqs returns the Requirements for stat jobs, according to our --req_*
+// command line options.
+func statReqs() *jqs.Requirements {
+	req := scheduler.DefaultRequirements()
+	req.RAM = statReqRAM
+	req.Time = statReqTime
+	req.Cores = statReqCores
+	req.Disk = statReqDisk
+
+	return req
 }
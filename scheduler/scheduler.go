@@ -27,7 +27,10 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/VertebrateResequencing/wr/jobqueue"
@@ -41,7 +44,9 @@ type Error string
 
 func (e Error) Error() string { return string(e) }
 
-const errDupJobs = Error("some of the added jobs were duplicates")
+// ErrDupJobs is returned by SubmitJobs() if some of the jobs were already in
+// the queue.
+const ErrDupJobs = Error("some of the added jobs were duplicates")
 
 // some consts for the jobs returned by NewJob().
 const jobRetries uint8 = 30
@@ -53,10 +58,11 @@ const reqDisk = 1
 // Scheduler can be used to schedule commands to be executed by adding them to
 // wr's queue.
 type Scheduler struct {
-	cwd  string
-	exe  string
-	jq   *jobqueue.Client
-	sudo bool
+	cwd    string
+	exe    string
+	jq     *jobqueue.Client
+	sudo   bool
+	dryRun io.Writer
 }
 
 // New returns a Scheduler that is connected to wr manager using the given
@@ -86,6 +92,25 @@ func New(deployment, cwd string, timeout time.Duration, logger log15.Logger,
 	}, err
 }
 
+// NewDryRun returns a Scheduler like New(), but that doesn't connect to wr
+// manager. Instead of adding jobs to wr's queue, SubmitJobs() writes details of
+// the jobs to the given writer.
+func NewDryRun(cwd string, w io.Writer, sudo bool) (*Scheduler, error) {
+	cwd, err := pickCWD(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := os.Executable()
+
+	return &Scheduler{
+		cwd:    cwd,
+		exe:    exe,
+		sudo:   sudo,
+		dryRun: w,
+	}, err
+}
+
 // pickCWD checks the given directory exists, returns an error. If the given
 // dir is blank, returns the current working directory.
 func pickCWD(cwd string) (string, error) {
@@ -115,9 +140,14 @@ func DefaultRequirements() *jqs.Requirements {
 	}
 }
 
+// DefaultRetries returns the number of retries NewJob() gives jobs.
+func DefaultRetries() uint8 {
+	return jobRetries
+}
+
 // NewJob is a convenience function for creating Jobs. It sets the job's Cwd
 // to the current working directory, sets CwdMatters to true, applies the given
-// Requirements, and sets Retries to 3.
+// Requirements, and sets Retries to DefaultRetries().
 //
 // If this Scheduler had been made with sudo: true, cmd will be prefixed with
 // 'sudo '.
@@ -190,21 +220,50 @@ func determineOverrideAndReq(req *jqs.Requirements) (*jqs.Requirements, uint8) {
 // again.
 //
 // If any duplicate jobs were added, an error will be returned.
+//
+// For a Scheduler from NewDryRun(), the jobs are written out instead.
 func (s *Scheduler) SubmitJobs(jobs []*jobqueue.Job) error {
+	if s.dryRun != nil {
+		return s.writeJobs(jobs)
+	}
+
 	inserts, _, err := s.jq.Add(jobs, os.Environ(), false)
 	if err != nil {
 		return err
 	}
 
 	if inserts != len(jobs) {
-		return errDupJobs
+		return ErrDupJobs
+	}
+
+	return nil
+}
+
+// writeJobs writes the details of the given jobs to our dryRun writer.
+func (s *Scheduler) writeJobs(jobs []*jobqueue.Job) error {
+	for _, job := range jobs {
+		deps := make([]string, len(job.Dependencies))
+		for i, dep := range job.Dependencies {
+			deps[i] = dep.DepGroup
+		}
+
+		if _, err := fmt.Fprintf(s.dryRun, "cmd: %s\nrep_grp: %s\nreq_grp: %s\ndep_grps: %s\ndeps: %s\n\n",
+			job.Cmd, job.RepGroup, job.ReqGroup,
+			strings.Join(job.DepGroups, ","), strings.Join(deps, ",")); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 // Disconnect disconnects from the manager. You should defer this after New().
+// Does nothing for a Scheduler from NewDryRun().
 func (s *Scheduler) Disconnect() error {
+	if s.jq == nil {
+		return nil
+	}
+
 	return s.jq.Disconnect()
 }
 
@@ -26,6 +26,7 @@
 package scheduler
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
@@ -87,6 +88,7 @@ func TestStatFile(t *testing.T) {
 				So(job.CwdMatters, ShouldBeTrue)
 				So(job.Requirements, ShouldResemble, &jqs.Requirements{RAM: 100, Time: 10 * time.Second, Cores: 1, Disk: 1})
 				So(job.Retries, ShouldEqual, 30)
+				So(DefaultRetries(), ShouldEqual, job.Retries)
 				So(job.DepGroups, ShouldBeNil)
 				So(job.Dependencies, ShouldBeNil)
 				So(job.Override, ShouldEqual, 0)
@@ -106,7 +108,7 @@ func TestStatFile(t *testing.T) {
 					Convey("but you get an error if there are duplicates", func() {
 						err = s.SubmitJobs([]*jobqueue.Job{job, job2})
 						So(err, ShouldNotBeNil)
-						So(err, ShouldEqual, errDupJobs)
+						So(err, ShouldEqual, ErrDupJobs)
 
 						info := server.GetServerStats()
 						So(info.Ready, ShouldEqual, 2)
@@ -185,6 +187,21 @@ func TestStatFile(t *testing.T) {
 		s, err := New(deployment, "", timeout, logger, false)
 		So(err, ShouldNotBeNil)
 		So(s, ShouldBeNil)
+
+		Convey("but you can make a dry-run Scheduler that writes out jobs", func() {
+			buff := new(bytes.Buffer)
+			s, err = NewDryRun("", buff, true)
+			So(err, ShouldBeNil)
+			So(s, ShouldNotBeNil)
+
+			job := s.NewJob("cmd", "rep", "req", "a", "b", nil)
+			err = s.SubmitJobs([]*jobqueue.Job{job})
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldEqual, "cmd: sudo cmd\nrep_grp: rep\nreq_grp: req\ndep_grps: a\ndeps: b\n\n")
+
+			err = s.Disconnect()
+			So(err, ShouldBeNil)
+		})
 	})
 }
 
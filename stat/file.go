@@ -67,16 +67,17 @@ type FileStats struct {
 	Ino        uint64
 	Nlink      uint64
 	Dev        uint64
+	DiskSize   int64
 }
 
 // ToString produces our special format for describing the stats of a file. It
 // is \n terminated and ready to be written to a file.
 func (fs *FileStats) ToString() string {
 	return fmt.Sprintf(
-		"%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%d\t%d\n",
+		"%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d\n",
 		fs.Base64Path, fs.Size, fs.UID, fs.GID,
 		fs.Atim, fs.Mtim, fs.Ctim,
-		fs.Type, fs.Ino, fs.Nlink, fs.Dev)
+		fs.Type, fs.Ino, fs.Nlink, fs.Dev, fs.DiskSize)
 }
 
 // correctSize will adjust our Size to stat.Blocks*stat.Blksize if our current
@@ -95,7 +96,7 @@ func File(absPath string, info os.FileInfo) *FileStats {
 	fs := &FileStats{
 		Base64Path: base64Encode(absPath),
 		Size:       info.Size(),
-		Type:       modeToType(info.Mode()),
+		Type:       ModeToType(info.Mode()),
 	}
 
 	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
@@ -107,6 +108,7 @@ func File(absPath string, info os.FileInfo) *FileStats {
 		fs.Ino = stat.Ino
 		fs.Nlink = stat.Nlink
 		fs.Dev = stat.Dev
+		fs.DiskSize = stat.Blocks * bytesPerBlock
 
 		fs.correctSize(stat)
 	}
@@ -119,9 +121,9 @@ func base64Encode(val string) string {
 	return base64.StdEncoding.EncodeToString([]byte(val))
 }
 
-// modeToType turns a FileMode retrieved from a FileInfo into one of our
+// ModeToType turns a FileMode (eg. retrieved from a FileInfo) into one of our
 // FileType constants.
-func modeToType(mode fs.FileMode) FileType {
+func ModeToType(mode fs.FileMode) FileType {
 	fileMode := mode.Type()
 	if fileMode.IsRegular() {
 		return FileTypeRegular
@@ -58,15 +58,15 @@ func TestStatFile(t *testing.T) {
 		So(fstat.Size, ShouldEqual, 0)
 	})
 
-	Convey("modeToType() works correctly", t, func() {
-		So(modeToType(fs.FileMode(0)), ShouldEqual, "f")
-		So(modeToType(fs.ModeDir), ShouldEqual, "d")
-		So(modeToType(fs.ModeSymlink), ShouldEqual, "l")
-		So(modeToType(fs.ModeSocket), ShouldEqual, "s")
-		So(modeToType(fs.ModeDevice), ShouldEqual, "b")
-		So(modeToType(fs.ModeCharDevice), ShouldEqual, "c")
-		So(modeToType(fs.ModeNamedPipe), ShouldEqual, "F")
-		So(modeToType(fs.ModeIrregular), ShouldEqual, "X")
+	Convey("ModeToType() works correctly", t, func() {
+		So(ModeToType(fs.FileMode(0)), ShouldEqual, "f")
+		So(ModeToType(fs.ModeDir), ShouldEqual, "d")
+		So(ModeToType(fs.ModeSymlink), ShouldEqual, "l")
+		So(ModeToType(fs.ModeSocket), ShouldEqual, "s")
+		So(ModeToType(fs.ModeDevice), ShouldEqual, "b")
+		So(ModeToType(fs.ModeCharDevice), ShouldEqual, "c")
+		So(ModeToType(fs.ModeNamedPipe), ShouldEqual, "F")
+		So(ModeToType(fs.ModeIrregular), ShouldEqual, "X")
 	})
 
 	Convey("base64Encode() works correctly", t, func() {
@@ -124,10 +124,11 @@ func testFileStats(path string, size int64, filetype string) {
 	So(stats.Ino, ShouldEqual, stat.Ino)
 	So(stats.Nlink, ShouldEqual, stat.Nlink)
 	So(stats.Dev, ShouldEqual, stat.Dev)
+	So(stats.DiskSize, ShouldEqual, stat.Blocks*bytesPerBlock)
 
 	So(stats.ToString(), ShouldEqual, fmt.Sprintf(
-		"%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%d\t%d\n",
+		"%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d\n",
 		"L2Ficy9wYXRoL3RvL2ZpbGU=", size, stat.Uid, stat.Gid,
 		stat.Atim.Sec, stat.Mtim.Sec, stat.Ctim.Sec,
-		filetype, stat.Ino, stat.Nlink, stat.Dev))
+		filetype, stat.Ino, stat.Nlink, stat.Dev, stat.Blocks*bytesPerBlock))
 }
@@ -29,6 +29,7 @@ import (
 	"bufio"
 	"io"
 	"io/fs"
+	"strings"
 	"sync"
 	"time"
 
@@ -53,6 +54,8 @@ type Paths struct {
 	reportFrequency time.Duration
 	ops             map[string]Operation
 	reporters       map[string]*reporter.Reporter
+	typedInput      bool
+	skipTypes       map[FileType]bool
 }
 
 // NewPaths returns a Paths that will use the given Statter to do the Lstat
@@ -85,9 +88,28 @@ func (p *Paths) AddOperation(name string, op Operation) error {
 	return nil
 }
 
+// TypedInput makes Scan() treat each line of its input as an absolute file path
+// followed by a tab and the path's FileType (as written by
+// github.com/wtsi-ssg/wrstat/walk Walker.EmitTypes()), instead of just a path.
+func (p *Paths) TypedInput() {
+	p.typedInput = true
+}
+
+// SkipTypes makes Scan() ignore paths of the given FileTypes, not passing them
+// to any Operation callbacks. With TypedInput(), such paths aren't even
+// Lstat()ed.
+func (p *Paths) SkipTypes(types ...FileType) {
+	p.skipTypes = make(map[FileType]bool, len(types))
+
+	for _, t := range types {
+		p.skipTypes[t] = true
+	}
+}
+
 // Scan scans through the given reader which should consist of an absolute file
-// path per line. It calls our Statter.Lstat() on each, and passes the absolute
-// path and FileInfo to any Operation callbacks you've added.
+// path per line (plus a FileType if TypedInput()). It calls our
+// Statter.Lstat() on each, and passes the absolute path and FileInfo to any
+// Operation callbacks you've added, unless the path is of a type in SkipTypes().
 //
 // Operations are run concurrently (so should not do something like write to the
 // same file) and their errors logged, but otherwise ignored.
@@ -104,12 +126,16 @@ func (p *Paths) Scan(paths io.Reader) error {
 	var wg sync.WaitGroup
 
 	for scanner.Scan() {
-		path := scanner.Text()
+		path, hint := p.parseLine(scanner.Text())
+		if p.skipTypes[hint] {
+			continue
+		}
+
 		info, err := p.timeLstat(r, path)
 
 		wg.Wait()
 
-		if err != nil {
+		if err != nil || p.skipInfo(info) {
 			continue
 		}
 
@@ -122,6 +148,31 @@ func (p *Paths) Scan(paths io.Reader) error {
 	return scanner.Err()
 }
 
+// parseLine returns the path in the given line of Scan() input, and the
+// FileType it was given if TypedInput(). The FileType is blank if untyped.
+func (p *Paths) parseLine(line string) (string, FileType) {
+	if !p.typedInput {
+		return line, ""
+	}
+
+	i := strings.LastIndexByte(line, '\t')
+	if i == -1 {
+		return line, ""
+	}
+
+	return line[:i], FileType(line[i+1:])
+}
+
+// skipInfo returns true if the given info is for a path of a type in our
+// SkipTypes().
+func (p *Paths) skipInfo(info fs.FileInfo) bool {
+	if len(p.skipTypes) == 0 {
+		return false
+	}
+
+	return p.skipTypes[ModeToType(info.Mode())]
+}
+
 // startReporting calls StartReproting on all our reporters.
 func (p *Paths) startReporting() {
 	if p.reportFrequency <= 0 {
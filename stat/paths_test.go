@@ -141,6 +141,56 @@ func TestPaths(t *testing.T) {
 			So(string(output), ShouldContainSubstring, "\tf\t")
 		})
 	})
+
+	Convey("Given a Paths that skips symlinks", t, func() {
+		_, l := newLogger()
+		s := &countingStatter{Statter: WithTimeout(statterTimeout, statterRetries, l)}
+		p := NewPaths(s, l, 0)
+		p.SkipTypes(FileTypeLink)
+
+		file, _ := createTestFiles(t)
+		link := filepath.Join(filepath.Dir(file), "link")
+		err := os.Symlink(file, link)
+		So(err, ShouldBeNil)
+
+		var seen []string
+
+		err = p.AddOperation("record", func(absPath string, info fs.FileInfo) error {
+			seen = append(seen, absPath)
+
+			return nil
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Untyped input is Lstat()ed to find symlinks", func() {
+			err = p.Scan(strings.NewReader(file + "\n" + link))
+			So(err, ShouldBeNil)
+			So(seen, ShouldResemble, []string{file})
+			So(s.calls, ShouldEqual, 2)
+		})
+
+		Convey("Typed input skips symlinks without an Lstat()", func() {
+			p.TypedInput()
+
+			err = p.Scan(strings.NewReader(file + "\tf\n" + link + "\tl\n/a\tb\tl"))
+			So(err, ShouldBeNil)
+			So(seen, ShouldResemble, []string{file})
+			So(s.calls, ShouldEqual, 1)
+		})
+	})
+}
+
+// countingStatter is a Statter that counts the Lstat() calls made on it.
+type countingStatter struct {
+	Statter
+	calls int
+}
+
+// Lstat counts the call and passes it through to the embedded Statter.
+func (c *countingStatter) Lstat(path string) (fs.FileInfo, error) {
+	c.calls++
+
+	return c.Statter.Lstat(path)
 }
 
 // addTestOperations adds a "check" and a "fail" operation to the given Paths,
@@ -0,0 +1,137 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package summary
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// XattrUnset is the value that files without the xattr being summarised on are
+// summarised under.
+const XattrUnset = "unset"
+
+// Xattr is used to summarise file stats by the value of an extended attribute.
+type Xattr struct {
+	name  string
+	store map[string]*summary
+}
+
+// NewByXattr returns an Xattr that will summarise on the value of the extended
+// attribute with the given name (eg. "user.project").
+func NewByXattr(name string) *Xattr {
+	return &Xattr{
+		name:  name,
+		store: make(map[string]*summary),
+	}
+}
+
+// Add is a github.com/wtsi-ssg/wrstat/stat Operation. It will add the file size
+// and increment the file count summed for the value of our xattr on the path.
+// Files without the xattr (including symlinks, whose xattrs aren't read) are
+// summed under XattrUnset. If path is a directory, it is ignored.
+func (x *Xattr) Add(path string, info fs.FileInfo) error {
+	if info.IsDir() {
+		return nil
+	}
+
+	value := XattrUnset
+	if info.Mode()&fs.ModeSymlink == 0 {
+		value = x.value(path)
+	}
+
+	s, ok := x.store[value]
+	if !ok {
+		s = &summary{}
+		x.store[value] = s
+	}
+
+	s.add(info.Size())
+
+	return nil
+}
+
+// value returns the value of our xattr on the given path, with tabs and
+// newlines replaced by spaces. Returns XattrUnset if the path doesn't have the
+// xattr, or it couldn't be read.
+func (x *Xattr) value(path string) string {
+	size, err := syscall.Getxattr(path, x.name, nil)
+	if err != nil || size == 0 {
+		return XattrUnset
+	}
+
+	buf := make([]byte, size)
+
+	size, err = syscall.Getxattr(path, x.name, buf)
+	if err != nil {
+		return XattrUnset
+	}
+
+	return sanitiseXattrValue(string(buf[:size]))
+}
+
+// sanitiseXattrValue replaces tabs and newlines in the given value with spaces,
+// since they would break our output format.
+func sanitiseXattrValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' {
+			return ' '
+		}
+
+		return r
+	}, value)
+}
+
+// Output will write summary information for all the paths previously added. The
+// format is (tab separated):
+//
+// value filecount filesize
+//
+// value is sorted. Returns an error on failure to write. output is closed on
+// completion.
+func (x *Xattr) Output(output *os.File) error {
+	values := make([]string, 0, len(x.store))
+
+	for value := range x.store {
+		values = append(values, value)
+	}
+
+	sort.Strings(values)
+
+	for _, value := range values {
+		s := x.store[value]
+
+		if _, err := output.WriteString(fmt.Sprintf("%s\t%d\t%d\n", value, s.count, s.size)); err != nil {
+			return err
+		}
+	}
+
+	return output.Close()
+}
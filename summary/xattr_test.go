@@ -0,0 +1,99 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package summary
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestXattr(t *testing.T) {
+	dir := t.TempDir()
+	tagged := filepath.Join(dir, "tagged")
+	untagged := filepath.Join(dir, "untagged")
+	link := filepath.Join(dir, "link")
+
+	for path, content := range map[string]string{tagged: "abc", untagged: "de"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if err := os.Symlink(tagged, link); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := syscall.Setxattr(tagged, "user.project", []byte("proj\tA"), 0); err != nil {
+		t.Skipf("user xattrs not supported: %s", err)
+	}
+
+	Convey("Given an Xattr", t, func() {
+		x := NewByXattr("user.project")
+		So(x, ShouldNotBeNil)
+
+		Convey("You can add file info to it which accumulates the info by xattr value", func() {
+			for _, path := range []string{tagged, untagged, link, dir} {
+				info, err := os.Lstat(path)
+				So(err, ShouldBeNil)
+
+				err = x.Add(path, info)
+				So(err, ShouldBeNil)
+			}
+
+			So(len(x.store), ShouldEqual, 2)
+			So(x.store["proj A"], ShouldResemble, &summary{1, 3})
+			So(x.store[XattrUnset].count, ShouldEqual, 2)
+
+			Convey("And then output the summaries to a file", func() {
+				outPath := filepath.Join(dir, "out")
+				out, err := os.Create(outPath)
+				So(err, ShouldBeNil)
+
+				err = x.Output(out)
+				So(err, ShouldBeNil)
+
+				o, err := os.ReadFile(outPath)
+				So(err, ShouldBeNil)
+				So(string(o), ShouldStartWith, "proj A\t1\t3\nunset\t2\t")
+			})
+
+			Convey("Output fails if we can't write to the output file", func() {
+				out, err := os.Create(filepath.Join(dir, "out"))
+				So(err, ShouldBeNil)
+
+				err = out.Close()
+				So(err, ShouldBeNil)
+
+				err = x.Output(out)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,140 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import "strings"
+
+// Balance makes Walk() write paths in batches of up to batchSize paths,
+// collected as they are found (so that paths in the same directory tend to stay
+// together), with each batch going to whichever output file currently has the
+// fewest lines. The output files will therefore differ in line count by at most
+// batchSize. The default of 0 means each path is written to the next output
+// file in turn.
+//
+// Call this before Walk().
+func (w *Walker) Balance(batchSize int) {
+	w.batchSize = batchSize
+}
+
+// pathWriter is something that writes paths to our output files. A pathWriter
+// should only be used by a single goroutine.
+type pathWriter interface {
+	// write writes (or queues for writing) the given path.
+	write(path string) error
+
+	// flush writes any queued paths.
+	flush() error
+}
+
+// newPathWriter returns a pathWriter that writes paths in the way configured
+// by Balance().
+func (w *Walker) newPathWriter() pathWriter {
+	if w.batchSize <= 0 {
+		return &roundRobinWriter{w: w}
+	}
+
+	return &batchWriter{w: w, paths: make([]string, 0, w.batchSize)}
+}
+
+// roundRobinWriter is a pathWriter that writes each path to the next output
+// file.
+type roundRobinWriter struct {
+	w *Walker
+}
+
+func (r *roundRobinWriter) write(path string) error {
+	return r.w.writePath(path)
+}
+
+func (r *roundRobinWriter) flush() error {
+	return nil
+}
+
+// batchWriter is a pathWriter that writes batches of paths to the least full
+// output file.
+type batchWriter struct {
+	w     *Walker
+	paths []string
+}
+
+func (b *batchWriter) write(path string) error {
+	b.paths = append(b.paths, path)
+
+	if len(b.paths) < b.w.batchSize {
+		return nil
+	}
+
+	return b.flush()
+}
+
+func (b *batchWriter) flush() error {
+	if len(b.paths) == 0 {
+		return nil
+	}
+
+	err := b.w.writeBatch(b.paths)
+	b.paths = b.paths[:0]
+
+	return err
+}
+
+// writeBatch is a thread-safe way of writing the given paths to the output file
+// with the fewest lines. Returns a WriteError on failure to write to the output
+// file.
+func (w *Walker) writeBatch(paths []string) error {
+	i := w.claimLeastFullFile(len(paths))
+
+	w.mus[i].Lock()
+	defer w.mus[i].Unlock()
+
+	_, err := w.outputs[i].WriteString(strings.Join(paths, "\n") + "\n")
+	if err != nil {
+		return &WriteError{Err: err}
+	}
+
+	w.countWritten(len(paths))
+
+	return nil
+}
+
+// claimLeastFullFile returns the index of the output file with the fewest
+// lines, and records that n more lines will be written to it.
+func (w *Walker) claimLeastFullFile(n int) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	least := 0
+
+	for i, count := range w.lineCounts {
+		if count < w.lineCounts[least] {
+			least = i
+		}
+	}
+
+	w.lineCounts[least] += n
+
+	return least
+}
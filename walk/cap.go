@@ -0,0 +1,88 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// CapError is the error given to your ErrorCallback when a directory was found
+// to contain more entries than the cap set with Walker.CapEntriesPerDir().
+type CapError struct {
+	Dir   string
+	Cap   int
+	Count int
+}
+
+func (e *CapError) Error() string {
+	return fmt.Sprintf("directory had %d entries, only the first %d were output", e.Count, e.Cap)
+}
+
+// dirCapper keeps track of how many entries we've seen in each directory, so
+// we can skip entries beyond a cap.
+type dirCapper struct {
+	cap    int
+	counts map[string]int
+}
+
+// newDirCapper returns a dirCapper that will skip entries beyond n per
+// directory. If n is 0 or less, nothing is ever skipped.
+func newDirCapper(n int) *dirCapper {
+	return &dirCapper{
+		cap:    n,
+		counts: make(map[string]int),
+	}
+}
+
+// skip records that we've seen path, and returns true if its parent directory
+// has now exceeded our cap.
+func (d *dirCapper) skip(path string) bool {
+	if d.cap <= 0 {
+		return false
+	}
+
+	parent := filepath.Dir(path)
+	d.counts[parent]++
+
+	return d.counts[parent] > d.cap
+}
+
+// done should be called once all the entries in dir have been seen. If dir
+// exceeded our cap, a *CapError is given to the callback. Either way, dir is
+// forgotten about.
+func (d *dirCapper) done(dir string, cb ErrorCallback) {
+	count, found := d.counts[dir]
+	if !found {
+		return
+	}
+
+	delete(d.counts, dir)
+
+	if count > d.cap {
+		cb(dir, &CapError{Dir: dir, Cap: d.cap, Count: count})
+	}
+}
@@ -0,0 +1,124 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"compress/gzip"
+	"os"
+)
+
+// Error is the type of some constant errors this package returns.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// ErrCompressResume is returned by Compress() when called on a Walker that is
+// resuming a previous Walk().
+const ErrCompressResume = Error("can't compress the output of a resumed walk")
+
+const compressedSuffix = ".gz"
+
+// outputFile is something we can write paths to.
+type outputFile interface {
+	WriteString(s string) (int, error)
+	Sync() error
+	Close() error
+}
+
+// compressedFile is an outputFile that gzip compresses what is written to it.
+type compressedFile struct {
+	file *os.File
+	zw   *gzip.Writer
+}
+
+// WriteString compresses s and writes it to our file.
+func (c *compressedFile) WriteString(s string) (int, error) {
+	return c.zw.Write([]byte(s))
+}
+
+// Sync flushes everything written so far to our file, and syncs it to disk.
+func (c *compressedFile) Sync() error {
+	if err := c.zw.Flush(); err != nil {
+		return err
+	}
+
+	return c.file.Sync()
+}
+
+// Close completes the compressed data and closes our file.
+func (c *compressedFile) Close() error {
+	if err := c.zw.Close(); err != nil {
+		c.file.Close()
+
+		return err
+	}
+
+	return c.file.Close()
+}
+
+// Compress makes Walk() gzip compress the paths it outputs, with the output
+// files being named walk.N.gz instead of walk.N. Returns ErrCompressResume for
+// a Walker from Resume() that is appending to existing output.
+//
+// Call this before Walk().
+func (w *Walker) Compress() error {
+	if w.appendOutput {
+		return ErrCompressResume
+	}
+
+	for i, file := range w.files {
+		compressed, err := replaceWithCompressedFile(file)
+		if err != nil {
+			return err
+		}
+
+		w.files[i] = compressed.file
+		w.outputs[i] = compressed
+	}
+
+	return nil
+}
+
+// replaceWithCompressedFile closes and deletes the given file, returning a
+// compressedFile for the same path with a .gz suffix.
+func replaceWithCompressedFile(file *os.File) (*compressedFile, error) {
+	path := file.Name()
+
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+
+	gzFile, err := os.Create(path + compressedSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressedFile{file: gzFile, zw: gzip.NewWriter(gzFile)}, nil
+}
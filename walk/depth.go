@@ -0,0 +1,69 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// MaxDepth makes Walk() only output paths at most n levels below the directory
+// it walks: directories n levels below are output but not descended in to. A
+// depth of 0 means only the walked directory itself is output. The default, or
+// a negative n, means no limit.
+//
+// Call this before Walk(). Afterwards, TruncatedDirs() tells you how many
+// directories weren't descended in to.
+func (w *Walker) MaxDepth(n int) {
+	w.maxDepth = n
+	w.limitDepth = n >= 0
+}
+
+// truncateAt returns true if a directory at the given depth below the walked
+// directory shouldn't be descended in to due to our MaxDepth(), counting it
+// for TruncatedDirs().
+func (w *Walker) truncateAt(depth int) bool {
+	if !w.limitDepth || depth < w.maxDepth {
+		return false
+	}
+
+	atomic.AddInt64(&w.truncated, 1)
+
+	return true
+}
+
+// TruncatedDirs returns the number of directories that Walk() didn't descend in
+// to due to MaxDepth().
+func (w *Walker) TruncatedDirs() int {
+	return int(atomic.LoadInt64(&w.truncated))
+}
+
+// depth returns how many levels below the walked directory the given path is.
+// Our root is a top level subdirectory of the walked directory, so is 1 level
+// below it.
+func (d *dirWalker) depth(path string) int {
+	return 1 + strings.Count(strings.TrimPrefix(path, d.root), "/")
+}
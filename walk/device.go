@@ -0,0 +1,78 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"os"
+	"syscall"
+)
+
+// OneFileSystem makes Walk() stay on the filesystem of the directory it is
+// walking, like 'find -xdev'. Directories on other filesystems (ie. mount
+// points) are output, but not descended in to.
+//
+// Call this before Walk().
+func (w *Walker) OneFileSystem() {
+	w.oneFileSystem = true
+}
+
+// recordRootDevice records the device of the given directory we're about to
+// walk, if we're staying on one filesystem.
+func (w *Walker) recordRootDevice(dir string) error {
+	if !w.oneFileSystem {
+		return nil
+	}
+
+	dev, err := deviceOf(dir)
+	w.rootDev = dev
+
+	return err
+}
+
+// otherDevice returns true if we're staying on one filesystem and the given
+// directory is on a different device to the one we're walking. Directories we
+// can't stat are treated as being on our device, so that the walk will report
+// the problem with them.
+func (w *Walker) otherDevice(dir string) bool {
+	if !w.oneFileSystem {
+		return false
+	}
+
+	dev, err := deviceOf(dir)
+
+	return err == nil && dev != w.rootDev
+}
+
+// deviceOf returns the id of the device the given path (or its target, if a
+// symlink) is on. NB: this will only work on linux.
+func deviceOf(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(info.Sys().(*syscall.Stat_t).Dev), nil //nolint:forcetypeassert,unconvert
+}
@@ -0,0 +1,79 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Exclude makes Walk() skip any entry that matches one of the given glob
+// patterns (as understood by filepath.Match()), along with everything nested
+// within it. Patterns containing a / are matched against the full path of an
+// entry, while other patterns are matched against its basename, so that eg.
+// ".snapshot" excludes .snapshot directories at any depth.
+//
+// Returns an error if any of the patterns are invalid.
+//
+// Call this before Walk().
+func (w *Walker) Exclude(patterns []string) error {
+	if err := ValidateExcludes(patterns); err != nil {
+		return err
+	}
+
+	w.excludes = patterns
+
+	return nil
+}
+
+// ValidateExcludes returns an error if any of the given patterns are not valid
+// for Exclude(). You can use this to check patterns before creating a Walker.
+func ValidateExcludes(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// excluded returns true if the given path matches one of our Exclude()
+// patterns.
+func (w *Walker) excluded(path string) bool {
+	for _, pattern := range w.excludes {
+		name := path
+		if !strings.Contains(pattern, "/") {
+			name = filepath.Base(path)
+		}
+
+		if matched, _ := filepath.Match(pattern, name); matched { //nolint:errcheck
+			return true
+		}
+	}
+
+	return false
+}
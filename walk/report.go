@@ -0,0 +1,110 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+// ReportProgress makes Walk() log the total number of paths output so far, and
+// the rate they were output at since the previous report, to the given logger
+// every frequency. Once the Walk() completes, the grand total and elapsed time
+// are logged. A frequency of 0 (the default) means no reporting.
+//
+// Call this before Walk().
+func (w *Walker) ReportProgress(logger log15.Logger, frequency time.Duration) {
+	w.reportLogger = logger
+	w.reportFrequency = frequency
+}
+
+// countWritten records that n more paths have been output.
+func (w *Walker) countWritten(n int) {
+	atomic.AddInt64(&w.written, int64(n))
+}
+
+// startReporting starts regularly reporting our progress if ReportProgress()
+// was called. Returns a function that stops reporting and gives the final
+// report, which does nothing if we're not reporting.
+func (w *Walker) startReporting() func() {
+	if w.reportFrequency <= 0 {
+		return func() {}
+	}
+
+	start := time.Now()
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+
+	go w.reportUntilStopped(start, stopCh, doneCh)
+
+	return func() {
+		close(stopCh)
+		<-doneCh
+
+		w.reportLogger.Info("walk complete",
+			"paths", atomic.LoadInt64(&w.written),
+			"elapsed", time.Since(start).Round(time.Second))
+	}
+}
+
+// reportUntilStopped logs our progress every reportFrequency until stopCh is
+// closed, then closes doneCh.
+func (w *Walker) reportUntilStopped(last time.Time, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+
+	ticker := time.NewTicker(w.reportFrequency)
+	defer ticker.Stop()
+
+	var lastCount int64
+
+	for {
+		select {
+		case now := <-ticker.C:
+			count := atomic.LoadInt64(&w.written)
+
+			w.reportLogger.Info("walk progress",
+				"paths", count,
+				"paths/s", pathsPerSecond(count-lastCount, now.Sub(last)))
+
+			last, lastCount = now, count
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// pathsPerSecond returns n/d.Seconds rounded to 2 decimal places, or n/a if d
+// is 0.
+func pathsPerSecond(n int64, d time.Duration) string {
+	if d <= 0 {
+		return "n/a"
+	}
+
+	return fmt.Sprintf("%.2f", float64(n)/d.Seconds())
+}
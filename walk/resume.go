@@ -0,0 +1,361 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const progressBasename = "walk.progress"
+
+// Resume is like New(), but if outDir contains the output of a previous Walk()
+// that was interrupted, that output is appended to instead of being replaced,
+// and the next Walk() of the same directory skips the top level subdirectories
+// that were completely walked last time. Paths previously output for
+// everything else are removed from the output files, since they will be output
+// again.
+//
+// The Walker records its progress, as if you had called RecordProgress().
+//
+// If outDir contains existing output files, their number is used instead of
+// numOutputFiles.
+//
+// If there is no record of a previous Walk() in outDir, or no uncompressed
+// output files (compressed output can't be resumed), or if the next Walk() is
+// of a different directory, this behaves exactly like New().
+func Resume(outDir string, numOutputFiles int) (*Walker, error) {
+	w := &Walker{outDir: outDir, recordProgress: true}
+
+	resumeFrom, err := readWalkProgress(outDir)
+	if err != nil {
+		return w, err
+	}
+
+	existing := w.countExistingOutputFiles()
+	if resumeFrom == nil || existing == 0 {
+		return w, w.createOutputFiles(numOutputFiles)
+	}
+
+	return w, w.resumeOutputFiles(resumeFrom, existing)
+}
+
+// resumeOutputFiles filters our n existing output files according to the given
+// progress, then opens them for appending to.
+func (w *Walker) resumeOutputFiles(resumeFrom *walkProgress, n int) error {
+	lineCounts := make([]int, n)
+
+	for i := range lineCounts {
+		count, err := resumeFrom.filterOutputFile(w.outputFilePath(i + 1))
+		if err != nil {
+			return err
+		}
+
+		lineCounts[i] = count
+	}
+
+	w.appendOutput = true
+
+	if err := w.createOutputFiles(n); err != nil {
+		return err
+	}
+
+	w.lineCounts = lineCounts
+	w.resumeFrom = resumeFrom
+
+	return nil
+}
+
+// countExistingOutputFiles returns how many consecutively numbered output files
+// already exist in our outDir.
+func (w *Walker) countExistingOutputFiles() int {
+	n := 0
+
+	for {
+		if _, err := os.Stat(w.outputFilePath(n + 1)); err != nil {
+			return n
+		}
+
+		n++
+	}
+}
+
+// RecordProgress makes Walk() keep a record of its progress in the output
+// directory, so that if it's interrupted it can be continued by using Resume().
+//
+// Recording progress involves syncing all the output files to disk each time a
+// top level subdirectory has been completely walked, which can be slow when
+// there are many output files.
+//
+// Call this before Walk().
+func (w *Walker) RecordProgress() {
+	w.recordProgress = true
+}
+
+// startProgress is called at the start of a Walk() of dir, to begin recording
+// our progress. If we're resuming a previous walk of dir, the previous progress
+// is used instead. If we're resuming the walk of a different dir, we truncate
+// our output files. If we're not recording progress, it is only tracked in
+// memory.
+func (w *Walker) startProgress(dir string) error {
+	if resumeFrom := w.resumeFrom; resumeFrom != nil {
+		w.resumeFrom = nil
+
+		if resumeFrom.root == dir {
+			w.progress = resumeFrom
+
+			return nil
+		}
+
+		if err := w.truncateOutputFiles(); err != nil {
+			return err
+		}
+	}
+
+	w.progress = newWalkProgress(w.outDir, dir)
+
+	if !w.recordProgress {
+		return nil
+	}
+
+	return w.progress.write()
+}
+
+// truncateOutputFiles empties all our output files.
+func (w *Walker) truncateOutputFiles() error {
+	for i, file := range w.files {
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+
+		w.lineCounts[i] = 0
+	}
+
+	return nil
+}
+
+// markComplete records that the given top level subdirectory has been
+// completely walked, after first ensuring that everything written to our
+// output files so far is on disk. Does nothing if we're not recording progress.
+func (w *Walker) markComplete(dir string) error {
+	if !w.recordProgress {
+		return nil
+	}
+
+	for i, output := range w.outputs {
+		w.mus[i].Lock()
+		err := output.Sync()
+		w.mus[i].Unlock()
+
+		if err != nil {
+			return &WriteError{Err: err}
+		}
+	}
+
+	if err := w.progress.complete(dir); err != nil {
+		return &WriteError{Err: err}
+	}
+
+	return nil
+}
+
+// walkProgress records which top level subdirectories of a walked directory
+// have been completely walked.
+type walkProgress struct {
+	path      string
+	root      string
+	completed map[string]bool
+	mu        sync.Mutex
+}
+
+// newWalkProgress returns a walkProgress for a walk of root, to be stored in
+// the given output directory.
+func newWalkProgress(outDir, root string) *walkProgress {
+	return &walkProgress{
+		path:      filepath.Join(outDir, progressBasename),
+		root:      root,
+		completed: make(map[string]bool),
+	}
+}
+
+// readWalkProgress reads the walkProgress stored in the given output
+// directory. Returns nil if there isn't one.
+func readWalkProgress(outDir string) (*walkProgress, error) {
+	file, err := os.Open(filepath.Join(outDir, progressBasename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+
+	p := newWalkProgress(outDir, scanner.Text())
+
+	for scanner.Scan() {
+		p.completed[scanner.Text()] = true
+	}
+
+	return p, scanner.Err()
+}
+
+// isComplete returns true if the given top level subdirectory was completely
+// walked.
+func (p *walkProgress) isComplete(dir string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.completed[dir]
+}
+
+// complete records that the given top level subdirectory has been completely
+// walked, and stores the new state on disk.
+func (p *walkProgress) complete(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.completed[dir] = true
+
+	return p.write()
+}
+
+// write atomically stores our state on disk, as our root followed by each
+// completed subdirectory, one per line.
+func (p *walkProgress) write() error {
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), progressBasename+".*")
+	if err != nil {
+		return err
+	}
+
+	lines := []string{p.root}
+	for dir := range p.completed {
+		lines = append(lines, dir)
+	}
+
+	if _, err = tmp.WriteString(strings.Join(lines, "\n") + "\n"); err == nil {
+		err = tmp.Sync()
+	}
+
+	if errc := tmp.Close(); err == nil {
+		err = errc
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p.path)
+}
+
+// keep returns true if the given previously output path is within a completed
+// top level subdirectory, so doesn't need to be output again. The path may be
+// followed by a tab and its type, if it was output with EmitTypes(); like
+// stat.Paths, we take the type to follow the last tab.
+func (p *walkProgress) keep(path string) bool {
+	if i := strings.LastIndexByte(path, '\t'); i >= 0 {
+		path = path[:i]
+	}
+
+	prefix := p.root
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	top := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)[0] //nolint:gomnd
+
+	return p.completed[prefix+top]
+}
+
+// filterOutputFile rewrites the given output file so that it only contains the
+// paths we keep(). Returns the number of paths kept.
+func (p *walkProgress) filterOutputFile(path string) (int, error) {
+	in, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(path + ".resume")
+	if err != nil {
+		return 0, err
+	}
+
+	kept, err := p.copyKeptPaths(in, out)
+	if errc := out.Close(); err == nil {
+		err = errc
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return kept, os.Rename(out.Name(), path)
+}
+
+// copyKeptPaths copies the paths we keep() from in to out, returning the number
+// copied.
+func (p *walkProgress) copyKeptPaths(in, out *os.File) (int, error) {
+	scanner := bufio.NewScanner(in)
+	writer := bufio.NewWriter(out)
+	kept := 0
+
+	for scanner.Scan() {
+		if !p.keep(scanner.Text()) {
+			continue
+		}
+
+		if _, err := writer.WriteString(scanner.Text() + "\n"); err != nil {
+			return 0, err
+		}
+
+		kept++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return kept, writer.Flush()
+}
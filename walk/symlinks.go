@@ -0,0 +1,101 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/karrick/godirwalk"
+)
+
+// dirID uniquely identifies a directory by its device and inode numbers.
+type dirID struct {
+	dev uint64
+	ino uint64
+}
+
+// FollowSymlinks makes Walk() descend in to symbolic links to directories, as
+// if they were the directories they point to. Paths within them are output
+// under the path of the symlink, not its target.
+//
+// To avoid infinite walks due to circular links, and outputting the same data
+// multiple times, each directory is only descended in to the first time it is
+// encountered: if the same directory is reached again (via a symlink or
+// otherwise), its path is output but not descended in to. Since subdirectories
+// are walked concurrently, which path a directory's contents end up being
+// output under is arbitrary when it can be reached in multiple ways.
+//
+// Call this before Walk().
+func (w *Walker) FollowSymlinks() {
+	w.followSymlinks = true
+	w.visited = make(map[dirID]bool)
+}
+
+// isDir returns true if the given directory entry at the given path is a
+// directory, or, if we're following symlinks, a symlink to a directory.
+func (w *Walker) isDir(path string, de *godirwalk.Dirent) bool {
+	if de.IsDir() {
+		return true
+	}
+
+	if !w.followSymlinks || !de.IsSymlink() {
+		return false
+	}
+
+	info, err := os.Stat(path)
+
+	return err == nil && info.IsDir()
+}
+
+// firstVisit returns true if we're not following symlinks, or if the given
+// directory hasn't been visited before during this Walk(), recording that it
+// has now been visited. Directories we can't stat count as first visits, so
+// that the walk will report the problem with them.
+func (w *Walker) firstVisit(dir string) bool {
+	if !w.followSymlinks {
+		return true
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return true
+	}
+
+	stat := info.Sys().(*syscall.Stat_t)              //nolint:forcetypeassert
+	id := dirID{dev: uint64(stat.Dev), ino: stat.Ino} //nolint:unconvert
+
+	w.visitedMu.Lock()
+	defer w.visitedMu.Unlock()
+
+	if w.visited[id] {
+		return false
+	}
+
+	w.visited[id] = true
+
+	return true
+}
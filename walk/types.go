@@ -0,0 +1,51 @@
+/*******************************************************************************
+ * Copyright (c) 2022 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package walk
+
+import (
+	"io/fs"
+
+	"github.com/wtsi-ssg/wrstat/stat"
+)
+
+// EmitTypes makes Walk() write each path followed by a tab and the
+// github.com/wtsi-ssg/wrstat/stat FileType of the entry, as determined from
+// the directory entry without an lstat. This lets consumers of the output (such
+// as stat.Paths with TypedInput()) avoid an lstat of paths they aren't
+// interested in.
+func (w *Walker) EmitTypes() {
+	w.emitTypes = true
+}
+
+// entryLine returns the line we should output for the given path with the
+// given type: just the path, or if EmitTypes(), the path and its FileType.
+func (w *Walker) entryLine(path string, mode fs.FileMode) string {
+	if !w.emitTypes {
+		return path
+	}
+
+	return path + "\t" + string(stat.ModeToType(mode))
+}
@@ -31,14 +31,18 @@ package walk
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/inconshreveable/log15"
 	"github.com/karrick/godirwalk"
 )
 
 const userOnlyPerm = 0700
+const outputFilePerm = 0666
 
 // WriteError is an error received when trying to write discovered paths to
 // disk.
@@ -53,18 +57,44 @@ func (e *WriteError) Unwrap() error { return e.Err }
 // Walker can be used to quickly walk a filesystem to just see what paths there
 // are on it.
 type Walker struct {
-	outDir   string
-	files    []*os.File
-	filesI   int
-	filesMax int
-	mu       sync.Mutex
-	mus      []sync.Mutex
+	outDir          string
+	files           []*os.File
+	outputs         []outputFile
+	filesI          int
+	filesMax        int
+	entriesCap      int
+	workers         int
+	batchSize       int
+	excludes        []string
+	lineCounts      []int
+	appendOutput    bool
+	oneFileSystem   bool
+	emitTypes       bool
+	followSymlinks  bool
+	visited         map[dirID]bool
+	visitedMu       sync.Mutex
+	maxDepth        int
+	limitDepth      bool
+	truncated       int64
+	rootDev         uint64
+	written         int64
+	reportLogger    log15.Logger
+	reportFrequency time.Duration
+	recordProgress  bool
+	progress        *walkProgress
+	resumeFrom      *walkProgress
+	mu              sync.Mutex
+	mus             []sync.Mutex
 }
 
 // New creates a new Walker that can Walk() a filesystem and write all the
 // encountered paths to the given number of output files in the given output
 // directory. The output files are created and opened ready for a Walk(). Any
 // error during that process is also returned.
+//
+// If you call RecordProgress(), a record of the progress of the Walk() is also
+// kept in the output directory, so that an interrupted Walk() can be continued
+// by using Resume() instead of New().
 func New(outDir string, numOutputFiles int) (*Walker, error) {
 	w := &Walker{
 		outDir: outDir,
@@ -83,6 +113,7 @@ func (w *Walker) createOutputFiles(n int) error {
 	}
 
 	files := make([]*os.File, n)
+	outputs := make([]outputFile, n)
 
 	for i := range files {
 		var err error
@@ -91,18 +122,43 @@ func (w *Walker) createOutputFiles(n int) error {
 		if err != nil {
 			return err
 		}
+
+		outputs[i] = files[i]
 	}
 
 	w.files = files
+	w.outputs = outputs
 	w.filesMax = len(files)
 	w.mus = make([]sync.Mutex, len(files))
+	w.lineCounts = make([]int, len(files))
 
 	return nil
 }
 
-// createOutputFile creates an output file ready for writing to.
+// createOutputFile creates an output file ready for writing to. If we're
+// resuming, an existing file is appended to instead of being truncated.
 func (w *Walker) createOutputFile(i int) (*os.File, error) {
-	return os.Create(filepath.Join(w.outDir, fmt.Sprintf("walk.%d", i)))
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if w.appendOutput {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	return os.OpenFile(w.outputFilePath(i), flag, outputFilePerm)
+}
+
+// outputFilePath returns the path to our ith output file.
+func (w *Walker) outputFilePath(i int) string {
+	return filepath.Join(w.outDir, fmt.Sprintf("walk.%d", i))
+}
+
+// CapEntriesPerDir makes Walk() only output the first n entries it finds in
+// any single directory. The remaining entries are skipped (and not descended in
+// to, if they are directories), and your ErrorCallback will be given a
+// *CapError for the directory. The default of 0 means no cap.
+//
+// Call this before Walk().
+func (w *Walker) CapEntriesPerDir(n int) {
+	w.entriesCap = n
 }
 
 // ErrorCallback is a callback function you supply Walker.Walk(), and it
@@ -119,12 +175,32 @@ type ErrorCallback func(path string, err error)
 // will mean the path isn't output, but the walk will continue and this method
 // won't return an error.
 func (w *Walker) Walk(dir string, cb ErrorCallback) error {
+	defer w.startReporting()()
+
+	if err := w.startProgress(dir); err != nil {
+		return &WriteError{Err: err}
+	}
+
+	if err := w.recordRootDevice(dir); err != nil {
+		cb(dir, err)
+
+		return nil
+	}
+
+	w.firstVisit(dir)
+
+	if w.truncateAt(0) {
+		return w.writeEntries(dir, []string{w.entryLine(dir, fs.ModeDir)}, cb)
+	}
+
 	subDirs, otherEntries, ok := w.getImmediateChildren(dir, cb)
 	if !ok {
 		return nil
 	}
 
-	if err := w.writeEntries(append(otherEntries, dir), cb); err != nil {
+	subDirs, otherEntries = w.capImmediateChildren(dir, subDirs, otherEntries, cb)
+
+	if err := w.writeEntries(dir, append(otherEntries, w.entryLine(dir, fs.ModeDir)), cb); err != nil {
 		return err
 	}
 
@@ -132,7 +208,10 @@ func (w *Walker) Walk(dir string, cb ErrorCallback) error {
 }
 
 // getImmediateChildren finds the immediate children of the given directory
-// and returns any entries that are subdirectories, then any other entries. Like
+// and returns any entries that are subdirectories, then any other entries (as
+// the lines to output for them), ignoring any that match our Exclude()
+// patterns. Subdirectories on other
+// devices when using OneFileSystem() count as other entries. Like
 // walkDir(), any failure to read is passed to the given callback, but we don't
 // return an error (just nil results and false).
 func (w *Walker) getImmediateChildren(dir string, cb ErrorCallback) ([]string, []string, bool) {
@@ -148,26 +227,57 @@ func (w *Walker) getImmediateChildren(dir string, cb ErrorCallback) ([]string, [
 	for _, child := range children {
 		path := filepath.Join(dir, child.Name())
 
-		if child.ModeType().IsDir() {
+		if w.excluded(path) {
+			continue
+		}
+
+		if w.isDir(path, child) && !w.otherDevice(path) {
 			subDirs = append(subDirs, path)
 		} else {
-			otherEntries = append(otherEntries, path)
+			otherEntries = append(otherEntries, w.entryLine(path, child.ModeType()))
 		}
 	}
 
 	return subDirs, otherEntries, true
 }
 
-// writeEntries writes the given paths to our output files.
-func (w *Walker) writeEntries(paths []string, cb ErrorCallback) error {
+// capImmediateChildren applies our entries cap to the given immediate children
+// of dir, preferring to keep the non-directory entries. If the cap was
+// exceeded, a *CapError is given to the callback.
+func (w *Walker) capImmediateChildren(dir string, subDirs, otherEntries []string,
+	cb ErrorCallback) ([]string, []string) {
+	count := len(subDirs) + len(otherEntries)
+	if w.entriesCap <= 0 || count <= w.entriesCap {
+		return subDirs, otherEntries
+	}
+
+	cb(dir, &CapError{Dir: dir, Cap: w.entriesCap, Count: count})
+
+	if len(otherEntries) >= w.entriesCap {
+		return nil, otherEntries[:w.entriesCap]
+	}
+
+	return subDirs[:w.entriesCap-len(otherEntries)], otherEntries
+}
+
+// writeEntries writes the given paths found in dir to our output files.
+func (w *Walker) writeEntries(dir string, paths []string, cb ErrorCallback) error {
+	pw := w.newPathWriter()
+
 	for _, path := range paths {
-		if err := w.writePath(path); err != nil {
+		if err := pw.write(path); err != nil {
 			cb(path, err)
 
 			return err
 		}
 	}
 
+	if err := pw.flush(); err != nil {
+		cb(dir, err)
+
+		return err
+	}
+
 	return nil
 }
 
@@ -182,34 +292,47 @@ func (w *Walker) writePath(path string) error {
 		w.filesI = 0
 	}
 
+	w.lineCounts[i]++
 	w.mu.Unlock()
 
 	w.mus[i].Lock()
 	defer w.mus[i].Unlock()
 
-	_, err := w.files[i].WriteString(path + "\n")
+	_, err := w.outputs[i].WriteString(path + "\n")
 	if err != nil {
-		err = &WriteError{Err: err}
+		return &WriteError{Err: err}
 	}
 
-	return err
+	w.countWritten(1)
+
+	return nil
 }
 
-// walkSubDirs calls walkDir() on each given subDir concurrently.
+// walkSubDirs calls walkDir() on each given subDir concurrently, using up to
+// our Workers() number of goroutines, skipping any that were completed by a
+// previous Walk() we're resuming. Returns the first error encountered.
 func (w *Walker) walkSubDirs(subDirs []string, cb ErrorCallback) error {
 	var wg sync.WaitGroup
 
+	dirCh := make(chan string, len(subDirs))
 	errCh := make(chan error, len(subDirs))
 
 	for _, dir := range subDirs {
+		dirCh <- dir
+	}
+
+	close(dirCh)
+
+	for i := 0; i < w.numWorkers(len(subDirs)); i++ {
 		wg.Add(1)
 
-		go func(thisDir string) {
+		go func() {
 			defer wg.Done()
 
-			werr := w.walkDir(thisDir, cb)
-			errCh <- werr
-		}(dir)
+			for dir := range dirCh {
+				errCh <- w.walkSubDir(dir, cb)
+			}
+		}()
 	}
 
 	wg.Wait()
@@ -223,33 +346,160 @@ func (w *Walker) walkSubDirs(subDirs []string, cb ErrorCallback) error {
 	return nil
 }
 
+// Workers makes Walk() use at most n goroutines to concurrently walk the top
+// level subdirectories of the directory it walks. The default of 0 means a
+// goroutine per subdirectory.
+//
+// Call this before Walk().
+func (w *Walker) Workers(n int) {
+	w.workers = n
+}
+
+// numWorkers returns the number of goroutines to use to walk the given number
+// of subdirectories.
+func (w *Walker) numWorkers(subDirs int) int {
+	if w.workers <= 0 || w.workers > subDirs {
+		return subDirs
+	}
+
+	return w.workers
+}
+
+// walkSubDir calls walkDir() on the given top level subdirectory, unless it was
+// already completed, and records its completion.
+func (w *Walker) walkSubDir(dir string, cb ErrorCallback) error {
+	if w.progress.isComplete(dir) {
+		return nil
+	}
+
+	if err := w.walkDir(dir, cb); err != nil {
+		return err
+	}
+
+	err := w.markComplete(dir)
+	if err != nil {
+		cb(dir, err)
+	}
+
+	return err
+}
+
 // walkDir walks the given directory, writing the paths to entries found to our
 // output files. Ends the walk if we fail to write to an output file, skips
-// entries we can't read. All errors are supplied to the given error callback.
+// entries we can't read, excluded entries and entries beyond our entries cap.
+// All errors are supplied to the given error callback.
 func (w *Walker) walkDir(dir string, cb ErrorCallback) error {
+	d := w.newDirWalker(dir, cb)
+
+	err := godirwalk.Walk(dir, &godirwalk.Options{
+		Callback:             d.callback,
+		PostChildrenCallback: d.postChildrenCallback,
+		ErrorCallback:        d.errorCallback,
+		FollowSymbolicLinks:  w.followSymlinks,
+		Unsorted:             true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.flush()
+}
+
+// dirWalker holds the state of a single walkDir() call.
+type dirWalker struct {
+	w      *Walker
+	root   string
+	cb     ErrorCallback
+	capper *dirCapper
+	pw     pathWriter
+}
+
+// newDirWalker returns a dirWalker for walking the given root directory.
+func (w *Walker) newDirWalker(root string, cb ErrorCallback) *dirWalker {
+	return &dirWalker{
+		w:      w,
+		root:   root,
+		cb:     cb,
+		capper: newDirCapper(w.entriesCap),
+		pw:     w.newPathWriter(),
+	}
+}
+
+// callback is a godirwalk.Options.Callback that writes the path, unless it is
+// excluded or beyond our entries cap. Directories on other devices when using
+// OneFileSystem(), directories already visited when using FollowSymlinks(),
+// and directories at our MaxDepth(), are written but not descended in to.
+func (d *dirWalker) callback(path string, de *godirwalk.Dirent) error {
+	if path != d.root && (d.w.excluded(path) || d.capper.skip(path)) {
+		return godirwalk.SkipThis
+	}
+
+	if err := d.pw.write(d.w.entryLine(path, de.ModeType())); err != nil {
+		return err
+	}
+
+	if !d.descend(path, de) {
+		return godirwalk.SkipThis
+	}
+
+	return nil
+}
+
+// descend returns false if the given written path is a directory we shouldn't
+// descend in to.
+func (d *dirWalker) descend(path string, de *godirwalk.Dirent) bool {
+	if !d.w.isDir(path, de) {
+		return true
+	}
+
+	if path != d.root && d.w.otherDevice(path) {
+		return false
+	}
+
+	if !d.w.firstVisit(path) {
+		return false
+	}
+
+	return !d.w.truncateAt(d.depth(path))
+}
+
+// postChildrenCallback is a godirwalk.Options.PostChildrenCallback that reports
+// on directories that exceeded our entries cap.
+func (d *dirWalker) postChildrenCallback(path string, de *godirwalk.Dirent) error {
+	d.capper.done(path, d.cb)
+
+	return nil
+}
+
+// errorCallback is a godirwalk.Options.ErrorCallback that passes errors to our
+// callback, halting on write errors and skipping anything else.
+func (d *dirWalker) errorCallback(path string, err error) godirwalk.ErrorAction {
 	var writeError *WriteError
 
-	return godirwalk.Walk(dir, &godirwalk.Options{
-		Callback: func(path string, de *godirwalk.Dirent) error {
-			return w.writePath(path)
-		},
-		ErrorCallback: func(path string, err error) godirwalk.ErrorAction {
-			cb(path, err)
+	d.cb(path, err)
 
-			if errors.As(err, &writeError) {
-				return godirwalk.Halt
-			}
+	if errors.As(err, &writeError) {
+		return godirwalk.Halt
+	}
 
-			return godirwalk.SkipNode
-		},
-		Unsorted: true,
-	})
+	return godirwalk.SkipNode
+}
+
+// flush writes any paths still queued by our pathWriter.
+func (d *dirWalker) flush() error {
+	err := d.pw.flush()
+	if err != nil {
+		d.cb(d.root, err)
+	}
+
+	return err
 }
 
-// Close should be called after Walk()ing to close all the output files.
+// Close should be called after Walk()ing to close all the output files. If
+// compressing, this is when the compressed data is completely written.
 func (w *Walker) Close() error {
-	for _, file := range w.files {
-		if err := file.Close(); err != nil {
+	for _, output := range w.outputs {
+		if err := output.Close(); err != nil {
 			return err
 		}
 	}
@@ -257,6 +507,18 @@ func (w *Walker) Close() error {
 	return nil
 }
 
+// OutputLineCounts gives you the number of paths written to each of the Walk()
+// output files, in the same order as OutputPaths().
+func (w *Walker) OutputLineCounts() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	counts := make([]int, len(w.lineCounts))
+	copy(counts, w.lineCounts)
+
+	return counts
+}
+
 // OutputPaths gives you the paths to the Walk() output files.
 func (w *Walker) OutputPaths() []string {
 	outPaths := make([]string, len(w.files))
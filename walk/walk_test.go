@@ -27,14 +27,19 @@ package walk
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/inconshreveable/log15"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -106,6 +111,17 @@ func TestWalk(t *testing.T) {
 			So(totalFound, ShouldEqual, 81)
 			So(len(walkErrors), ShouldEqual, 0)
 
+			counts := w.OutputLineCounts()
+			So(len(counts), ShouldEqual, n)
+
+			totalCounted := 0
+			for i, count := range counts {
+				So(count, ShouldEqual, len(readOutputPaths(t, outPaths[i])))
+				totalCounted += count
+			}
+
+			So(totalCounted, ShouldEqual, 81)
+
 			err = w.Close()
 			So(err, ShouldBeNil)
 
@@ -116,6 +132,336 @@ func TestWalk(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 
+		Convey("You can output the paths to multiple files in balanced batches", func() {
+			n := 4
+			batchSize := 5
+			w, err := New(outDir, n)
+			So(err, ShouldBeNil)
+
+			w.Balance(batchSize)
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			totalFound := 0
+			minFound, maxFound := len(expectedPaths), 0
+
+			for _, outPath := range w.OutputPaths() {
+				content, errr := os.ReadFile(outPath)
+				So(errr, ShouldBeNil)
+
+				found, dups, _ := checkPaths(string(content), expectedPaths)
+				So(dups, ShouldEqual, 0)
+				totalFound += found
+
+				if found < minFound {
+					minFound = found
+				}
+
+				if found > maxFound {
+					maxFound = found
+				}
+			}
+
+			So(totalFound, ShouldEqual, 81)
+			So(maxFound-minFound, ShouldBeLessThanOrEqualTo, batchSize)
+			So(len(walkErrors), ShouldEqual, 0)
+		})
+
+		Convey("Write errors during a balanced walk are reported and the walk terminated", func() {
+			w, err := New(outDir, 1)
+			So(err, ShouldBeNil)
+
+			w.Balance(5)
+
+			err = w.files[0].Close()
+			So(err, ShouldBeNil)
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldNotBeNil)
+			So(len(walkErrors), ShouldEqual, 1)
+
+			var writeError *WriteError
+			So(errors.As(walkErrors[0], &writeError), ShouldBeTrue)
+		})
+
+		Convey("You can exclude paths matching patterns from the output", func() {
+			w, err := New(outDir, 1)
+			So(err, ShouldBeNil)
+
+			excludedFullPath := filepath.Join(walkDir, "1")
+
+			err = w.Exclude([]string{"2", filepath.Join(walkDir, "1")})
+			So(err, ShouldBeNil)
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			for _, path := range readOutputPaths(t, filepath.Join(outDir, "walk.1")) {
+				So(filepath.Base(path), ShouldNotEqual, "2")
+				So(strings.HasPrefix(path, excludedFullPath+"/"), ShouldBeFalse)
+				So(path, ShouldNotEqual, excludedFullPath)
+			}
+
+			So(len(walkErrors), ShouldEqual, 0)
+		})
+
+		Convey("You can output the paths to compressed files", func() {
+			n := 2
+			w, err := New(outDir, n)
+			So(err, ShouldBeNil)
+
+			err = w.Compress()
+			So(err, ShouldBeNil)
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			err = w.Close()
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(outDir, "walk.1"))
+			So(err, ShouldNotBeNil)
+
+			content := ""
+
+			for i, path := range w.OutputPaths() {
+				So(path, ShouldEqual, filepath.Join(outDir, fmt.Sprintf("walk.%d.gz", i+1)))
+
+				content += readCompressedOutput(t, path)
+			}
+
+			found, dups, missing := checkPaths(content, expectedPaths)
+			So(found, ShouldEqual, 81)
+			So(dups, ShouldEqual, 0)
+			So(missing, ShouldEqual, 0)
+			So(len(walkErrors), ShouldEqual, 0)
+		})
+
+		Convey("You can stay on one filesystem", func() {
+			w, err := New(outDir, 1)
+			So(err, ShouldBeNil)
+
+			w.OneFileSystem()
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			content, err := os.ReadFile(filepath.Join(outDir, "walk.1"))
+			So(err, ShouldBeNil)
+
+			found, dups, missing := checkPaths(string(content), expectedPaths)
+			So(found, ShouldEqual, 81)
+			So(dups, ShouldEqual, 0)
+			So(missing, ShouldEqual, 0)
+			So(len(walkErrors), ShouldEqual, 0)
+
+			Convey("and directories on other devices are not descended in to", func() {
+				w, err = New(outDir, 1)
+				So(err, ShouldBeNil)
+
+				w.OneFileSystem()
+				w.rootDev++
+
+				err = w.walkDir(walkDir, cb)
+				So(err, ShouldBeNil)
+
+				paths := readOutputPaths(t, filepath.Join(outDir, "walk.1"))
+				So(len(paths), ShouldEqual, 9)
+				So(paths, ShouldContain, filepath.Join(walkDir, "1"))
+				So(paths, ShouldNotContain, filepath.Join(walkDir, "1", "1.file"))
+			})
+		})
+
+		Convey("You can output the paths with their types", func() {
+			w, err := New(outDir, 1)
+			So(err, ShouldBeNil)
+
+			w.EmitTypes()
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			content, err := os.ReadFile(filepath.Join(outDir, "walk.1"))
+			So(err, ShouldBeNil)
+
+			lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+			paths := make([]string, len(lines))
+			types := make(map[string]string)
+
+			for i, line := range lines {
+				cols := strings.Split(line, "\t")
+				So(len(cols), ShouldEqual, 2)
+
+				paths[i] = cols[0]
+				types[cols[0]] = cols[1]
+			}
+
+			So(types[walkDir], ShouldEqual, "d")
+			So(types[filepath.Join(walkDir, "1")], ShouldEqual, "d")
+			So(types[filepath.Join(walkDir, "1.file")], ShouldEqual, "f")
+			So(types[filepath.Join(walkDir, "1", "2", "1.file")], ShouldEqual, "f")
+
+			found, dups, missing := checkPaths(strings.Join(paths, "\n")+"\n", expectedPaths)
+			So(found, ShouldEqual, 81)
+			So(dups, ShouldEqual, 0)
+			So(missing, ShouldEqual, 0)
+		})
+
+		Convey("You can follow symlinks to directories, without looping", func() {
+			other := filepath.Join(filepath.Dir(walkDir), "other")
+			err := os.Mkdir(other, os.ModePerm)
+			So(err, ShouldBeNil)
+
+			err = os.WriteFile(filepath.Join(other, "file"), []byte("a"), userOnlyPerm)
+			So(err, ShouldBeNil)
+
+			ext := filepath.Join(walkDir, "1", "ext")
+			loop := filepath.Join(walkDir, "1", "2", "loop")
+
+			So(os.Symlink(other, ext), ShouldBeNil)
+			So(os.Symlink(filepath.Join(walkDir, "1"), loop), ShouldBeNil)
+
+			expectedPaths[ext] = 0
+			expectedPaths[loop] = 0
+
+			w, err := New(outDir, 1)
+			So(err, ShouldBeNil)
+
+			Convey("by default they are not followed", func() {
+				err = w.Walk(walkDir, cb)
+				So(err, ShouldBeNil)
+
+				content, err := os.ReadFile(filepath.Join(outDir, "walk.1"))
+				So(err, ShouldBeNil)
+
+				found, dups, missing := checkPaths(string(content), expectedPaths)
+				So(found, ShouldEqual, 83)
+				So(dups, ShouldEqual, 0)
+				So(missing, ShouldEqual, 0)
+				So(len(readOutputPaths(t, filepath.Join(outDir, "walk.1"))), ShouldEqual, 83)
+			})
+
+			Convey("with FollowSymlinks() they are", func() {
+				w.FollowSymlinks()
+
+				err = w.Walk(walkDir, cb)
+				So(err, ShouldBeNil)
+
+				content, err := os.ReadFile(filepath.Join(outDir, "walk.1"))
+				So(err, ShouldBeNil)
+
+				expectedPaths[filepath.Join(ext, "file")] = 0
+
+				found, dups, missing := checkPaths(string(content), expectedPaths)
+				So(found, ShouldEqual, 84)
+				So(dups, ShouldEqual, 0)
+				So(missing, ShouldEqual, 0)
+				So(len(readOutputPaths(t, filepath.Join(outDir, "walk.1"))), ShouldEqual, 84)
+				So(len(walkErrors), ShouldEqual, 0)
+			})
+		})
+
+		Convey("You can limit the depth of the walk", func() {
+			for _, test := range []struct {
+				depth, paths, truncated int
+			}{
+				{0, 1, 1},
+				{1, 9, 4},
+				{2, 33, 12},
+				{3, 81, 24},
+				{-1, 81, 0},
+			} {
+				thisOutDir := filepath.Join(outDir, fmt.Sprintf("depth%d", test.depth))
+
+				w, err := New(thisOutDir, 1)
+				So(err, ShouldBeNil)
+
+				w.MaxDepth(test.depth)
+
+				err = w.Walk(walkDir, cb)
+				So(err, ShouldBeNil)
+
+				paths := readOutputPaths(t, filepath.Join(thisOutDir, "walk.1"))
+				So(len(paths), ShouldEqual, test.paths)
+				So(w.TruncatedDirs(), ShouldEqual, test.truncated)
+
+				if test.depth == 0 {
+					So(paths[0], ShouldEqual, walkDir)
+				}
+			}
+
+			So(len(walkErrors), ShouldEqual, 0)
+		})
+
+		Convey("You can have progress reported", func() {
+			w, err := New(outDir, 1)
+			So(err, ShouldBeNil)
+
+			buff := new(bytes.Buffer)
+			l := log15.New()
+			l.SetHandler(log15.StreamHandler(buff, log15.LogfmtFormat()))
+
+			w.ReportProgress(l, time.Millisecond)
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			So(buff.String(), ShouldContainSubstring, `lvl=info msg="walk complete" paths=81 elapsed=`)
+
+			buff.Reset()
+			stop := w.startReporting()
+			<-time.After(5 * time.Millisecond)
+			stop()
+
+			So(buff.String(), ShouldContainSubstring, `lvl=info msg="walk progress" paths=81 paths/s=`)
+			So(buff.String(), ShouldContainSubstring, `lvl=info msg="walk complete" paths=81`)
+		})
+
+		Convey("You can limit the number of concurrent subdirectory walks", func() {
+			for _, workers := range []int{1, 2, 10} {
+				outPaths := make(map[string]int, len(expectedPaths))
+				for path := range expectedPaths {
+					outPaths[path] = 0
+				}
+
+				w, err := New(outDir, 2)
+				So(err, ShouldBeNil)
+
+				w.Workers(workers)
+
+				err = w.Walk(walkDir, cb)
+				So(err, ShouldBeNil)
+
+				content := ""
+
+				for _, path := range w.OutputPaths() {
+					b, errr := os.ReadFile(path)
+					So(errr, ShouldBeNil)
+
+					content += string(b)
+				}
+
+				found, dups, missing := checkPaths(content, outPaths)
+				So(found, ShouldEqual, 81)
+				So(dups, ShouldEqual, 0)
+				So(missing, ShouldEqual, 0)
+			}
+
+			So(len(walkErrors), ShouldEqual, 0)
+		})
+
+		Convey("You can't exclude using an invalid pattern", func() {
+			w, err := New(outDir, 1)
+			So(err, ShouldBeNil)
+
+			err = w.Exclude([]string{"["})
+			So(err, ShouldNotBeNil)
+
+			So(ValidateExcludes([]string{"["}), ShouldNotBeNil)
+			So(ValidateExcludes([]string{"2", "*.file"}), ShouldBeNil)
+		})
+
 		Convey("Write errors during a walk are reported and the walk terminated", func() {
 			w, err := New(outDir, 1)
 			So(err, ShouldBeNil)
@@ -158,6 +504,78 @@ func TestWalk(t *testing.T) {
 		})
 	})
 
+	Convey("Given a directory with more entries than a cap", t, func() {
+		tmpDir := t.TempDir()
+		capDir := filepath.Join(tmpDir, "cap")
+		bigDir := filepath.Join(capDir, "big")
+		outDir := filepath.Join(tmpDir, "out")
+
+		err := os.MkdirAll(bigDir, os.ModePerm)
+		So(err, ShouldBeNil)
+
+		smallFile := filepath.Join(capDir, "a")
+		err = os.WriteFile(smallFile, []byte("a"), userOnlyPerm)
+		So(err, ShouldBeNil)
+
+		for i := 1; i <= 10; i++ {
+			err = os.WriteFile(filepath.Join(bigDir, fmt.Sprintf("%d", i)), []byte("b"), userOnlyPerm)
+			So(err, ShouldBeNil)
+		}
+
+		var capErrors []*CapError
+		cb := func(_ string, err error) {
+			var capError *CapError
+			if errors.As(err, &capError) {
+				capErrors = append(capErrors, capError)
+			}
+		}
+
+		w, err := New(outDir, 1)
+		So(err, ShouldBeNil)
+
+		Convey("Only the first N entries of the directory are output, and a CapError is given", func() {
+			w.CapEntriesPerDir(3)
+
+			err = w.Walk(capDir, cb)
+			So(err, ShouldBeNil)
+
+			paths := readOutputPaths(t, filepath.Join(outDir, "walk.1"))
+			So(len(paths), ShouldEqual, 6)
+			So(paths, ShouldContain, capDir)
+			So(paths, ShouldContain, smallFile)
+			So(paths, ShouldContain, bigDir)
+
+			So(len(capErrors), ShouldEqual, 1)
+			So(capErrors[0].Dir, ShouldEqual, bigDir)
+			So(capErrors[0].Cap, ShouldEqual, 3)
+			So(capErrors[0].Count, ShouldEqual, 10)
+			So(capErrors[0].Error(), ShouldEqual, "directory had 10 entries, only the first 3 were output")
+		})
+
+		Convey("Capping the walked directory itself skips its sub directories first", func() {
+			w.CapEntriesPerDir(1)
+
+			err = w.Walk(capDir, cb)
+			So(err, ShouldBeNil)
+
+			paths := readOutputPaths(t, filepath.Join(outDir, "walk.1"))
+			So(paths, ShouldResemble, []string{smallFile, capDir})
+
+			So(len(capErrors), ShouldEqual, 1)
+			So(capErrors[0].Dir, ShouldEqual, capDir)
+			So(capErrors[0].Count, ShouldEqual, 2)
+		})
+
+		Convey("Without a cap, all entries are output", func() {
+			err = w.Walk(capDir, cb)
+			So(err, ShouldBeNil)
+
+			paths := readOutputPaths(t, filepath.Join(outDir, "walk.1"))
+			So(len(paths), ShouldEqual, 13)
+			So(len(capErrors), ShouldEqual, 0)
+		})
+	})
+
 	Convey("You can't make a Walker on a bad directory", t, func() {
 		_, err := New("/foo", 1)
 		So(err, ShouldNotBeNil)
@@ -172,6 +590,114 @@ func TestWalk(t *testing.T) {
 	})
 }
 
+func TestResume(t *testing.T) {
+	Convey("Given a directory to walk and an output directory", t, func() {
+		walkDir, outDir, expectedPaths := prepareTestDirs(t)
+		progressPath := filepath.Join(outDir, progressBasename)
+		cb := func(_ string, err error) {}
+
+		Convey("A Walk doesn't record its progress by default", func() {
+			w, err := New(outDir, 2)
+			So(err, ShouldBeNil)
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(progressPath)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A Walk can record its progress", func() {
+			w, err := New(outDir, 2)
+			So(err, ShouldBeNil)
+
+			w.RecordProgress()
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			lines := readOutputPaths(t, progressPath)
+			So(len(lines), ShouldEqual, 5)
+			So(lines[0], ShouldEqual, walkDir)
+			So(lines[1:], ShouldContain, filepath.Join(walkDir, "4"))
+
+			Convey("and an interrupted Walk can be resumed", func() {
+				err = os.WriteFile(progressPath, []byte(walkDir+"\n"+filepath.Join(walkDir, "1")+"\n"), userOnlyPerm)
+				So(err, ShouldBeNil)
+
+				f, errf := os.OpenFile(filepath.Join(outDir, "walk.2"), os.O_APPEND|os.O_WRONLY, 0)
+				So(errf, ShouldBeNil)
+				_, err = f.WriteString(filepath.Join(walkDir, "2", "1.file") + "\n")
+				So(err, ShouldBeNil)
+				So(f.Close(), ShouldBeNil)
+
+				w, err = Resume(outDir, 4)
+				So(err, ShouldBeNil)
+				So(len(w.OutputPaths()), ShouldEqual, 2)
+
+				err = w.Walk(walkDir, cb)
+				So(err, ShouldBeNil)
+				So(w.Close(), ShouldBeNil)
+
+				content := ""
+
+				for _, path := range w.OutputPaths() {
+					b, errr := os.ReadFile(path)
+					So(errr, ShouldBeNil)
+
+					content += string(b)
+				}
+
+				found, dups, missing := checkPaths(content, expectedPaths)
+				So(found, ShouldEqual, 81)
+				So(dups, ShouldEqual, 0)
+				So(missing, ShouldEqual, 0)
+				So(len(readOutputPaths(t, progressPath)), ShouldEqual, 5)
+			})
+
+			Convey("and resuming a Walk of a different directory starts again", func() {
+				otherDir := filepath.Join(walkDir, "1")
+				w, err = Resume(outDir, 1)
+				So(err, ShouldBeNil)
+
+				err = w.Walk(otherDir, cb)
+				So(err, ShouldBeNil)
+
+				paths := readOutputPaths(t, filepath.Join(outDir, "walk.1"))
+				paths = append(paths, readOutputPaths(t, filepath.Join(outDir, "walk.2"))...)
+				So(len(paths), ShouldEqual, 19)
+				So(paths, ShouldContain, otherDir)
+				So(paths, ShouldNotContain, walkDir)
+				So(readOutputPaths(t, progressPath)[0], ShouldEqual, otherDir)
+			})
+
+			Convey("but resumed output can't be compressed", func() {
+				w, err = Resume(outDir, 1)
+				So(err, ShouldBeNil)
+
+				err = w.Compress()
+				So(err, ShouldEqual, ErrCompressResume)
+			})
+		})
+
+		Convey("Resuming without a previous Walk is like a new Walk", func() {
+			w, err := Resume(outDir, 1)
+			So(err, ShouldBeNil)
+
+			err = w.Walk(walkDir, cb)
+			So(err, ShouldBeNil)
+
+			content, err := os.ReadFile(filepath.Join(outDir, "walk.1"))
+			So(err, ShouldBeNil)
+
+			found, dups, missing := checkPaths(string(content), expectedPaths)
+			So(found, ShouldEqual, 81)
+			So(dups, ShouldEqual, 0)
+			So(missing, ShouldEqual, 0)
+		})
+	})
+}
+
 // prepareTestDirs creates a temporary directory filled with files to walk, and
 // an empty directory you can output to. Also returns all the paths created in a
 // map.
@@ -224,6 +750,43 @@ func fillDirWithFiles(t *testing.T, dir string, size int, paths map[string]int)
 	}
 }
 
+// readOutputPaths returns the lines in the given Walk() output file.
+func readOutputPaths(t *testing.T, path string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+
+	return strings.Fields(string(content))
+}
+
+// readCompressedOutput returns the decompressed content of the given compressed
+// Walk() output file.
+func readCompressedOutput(t *testing.T, path string) string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open failed: %s", err)
+	}
+
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip reader failed: %s", err)
+	}
+
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompression failed: %s", err)
+	}
+
+	return string(content)
+}
+
 // checkPaths parses the string content of a Walk() output file and marks how
 // many times given paths were found in the map, returning numbers found,
 // duplicated and not found.